@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"imageApi/controllers"
+	"imageApi/models"
+	"os"
+	"strings"
+	"time"
+)
+
+const usage = `usage: imageApi [command]
+
+Without a command the API server is started.
+
+commands:
+  import <dir>              index every image below dir as public images
+                            without an owner, skipping copies of files
+                            that are already indexed; dir must be below
+                            one of IMAGE_LIBRARY_ROOTS
+  migrate up|down|status    apply, revert or list schema migrations
+  user add <username> <role>
+                            create a user with role admin, editor or
+                            viewer, reading the password from the first
+                            line of standard input; this is how the first
+                            admin is made`
+
+// runCommand runs a command line subcommand and returns the exit code.
+func runCommand(args []string) int {
+	switch args[0] {
+	case "import":
+		return runImport(args[1:])
+	case "migrate":
+		return runMigrate(args[1:])
+	case "user":
+		return runUser(args[1:])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+}
+
+func runImport(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: imageApi import <dir>")
+		return 2
+	}
+
+	models.ConnectDatabase()
+
+	if _, err := models.MigrateUp(); err != nil {
+		fmt.Fprintln(os.Stderr, "migration failed:", err)
+		return 1
+	}
+
+	if _, err := controllers.LoadMetadataExtractor(); err != nil {
+		fmt.Fprintln(os.Stderr, "metadata extractor error:", err)
+		return 1
+	}
+	defer controllers.CloseMetadataExtractor()
+
+	images := controllers.NewImageController(newImageStore())
+
+	result, err := images.ImportDirectory(context.Background(), args[0], controllers.ImportOptions{
+		Admin:       true,
+		Visibility:  models.VisibilityPublic,
+		OnDuplicate: "link"})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "import failed:", err)
+		return 1
+	}
+
+	out, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(out))
+
+	if result.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func runMigrate(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: imageApi migrate up|down|status")
+		return 2
+	}
+
+	models.ConnectDatabase()
+
+	switch args[0] {
+	case "up":
+		applied, err := models.MigrateUp()
+		for _, migration := range applied {
+			fmt.Printf("applied %d %s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "migrate up failed:", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+	case "down":
+		reverted, err := models.MigrateDown()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "migrate down failed:", err)
+			return 1
+		}
+		if reverted == nil {
+			fmt.Println("no applied migrations")
+		} else {
+			fmt.Printf("reverted %d %s\n", reverted.Version, reverted.Name)
+		}
+	case "status":
+		statuses, err := models.MigrationStatuses()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "migrate status failed:", err)
+			return 1
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.Applied {
+				applied = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-30s %s\n", status.Version, status.Name, applied)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "usage: imageApi migrate up|down|status")
+		return 2
+	}
+	return 0
+}
+
+func runUser(args []string) int {
+	if len(args) != 3 || args[0] != "add" {
+		fmt.Fprintln(os.Stderr, "usage: imageApi user add <username> admin|editor|viewer")
+		return 2
+	}
+	username, role := args[1], args[2]
+	if !models.ValidRole(role) {
+		fmt.Fprintln(os.Stderr, "role must be admin, editor or viewer")
+		return 2
+	}
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		fmt.Fprintln(os.Stderr, "no password on standard input")
+		return 2
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if len(password) < 8 || len(password) > 72 {
+		fmt.Fprintln(os.Stderr, "password must be 8 to 72 bytes long")
+		return 2
+	}
+
+	models.ConnectDatabase()
+
+	if _, err := models.MigrateUp(); err != nil {
+		fmt.Fprintln(os.Stderr, "migration failed:", err)
+		return 1
+	}
+
+	user, err := models.CreateUser(username, password, role)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "user add failed:", err)
+		return 1
+	}
+	fmt.Printf("created user %d %s (%s)\n", user.UserID, user.Username, user.UserRole)
+	return 0
+}
@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"imageApi/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// currentUserID returns the user the auth middleware found on the request,
+// or 0 for an anonymous request.
+func currentUserID(c *gin.Context) uint {
+	if userID, ok := c.Get("user_id"); ok {
+		return userID.(uint)
+	}
+	return 0
+}
+
+func isAdmin(c *gin.Context) bool {
+	return c.GetString("role") == models.RoleAdmin
+}
+
+// imageAccess limits image searches to what the caller may see. Admins see
+// every image.
+func imageAccess(c *gin.Context) *models.ImageAccess {
+	if isAdmin(c) {
+		return nil
+	}
+	return &models.ImageAccess{UserID: currentUserID(c)}
+}
+
+// findEditableImage loads the image named by the image_id path parameter and
+// checks that the caller may change it. Images the caller cannot see are
+// reported as missing, ones they can only see as forbidden.
+func (ic *ImageController) findEditableImage(c *gin.Context) (models.Image, bool) {
+	image, ok := ic.findImage(c)
+	if !ok {
+		return image, false
+	}
+
+	if !isAdmin(c) && !image.EditableBy(currentUserID(c)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You may not change this image"})
+		return image, false
+	}
+	return image, true
+}
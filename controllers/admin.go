@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"imageApi/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bulk operations take at most this many image IDs per request.
+const maxBulkImages = 1000
+
+type UpdateUserInput struct {
+	Role     string `json:"role"`
+	Disabled *bool  `json:"disabled"`
+}
+
+type BulkImagesInput struct {
+	ImageIDs []int `json:"imageids" binding:"required"`
+}
+
+// ReindexInput selects the images to reindex. No IDs means every image.
+type ReindexInput struct {
+	ImageIDs []int `json:"imageids"`
+}
+
+// BulkDeleteResult lists what a bulk delete did.
+type BulkDeleteResult struct {
+	Deleted []int `json:"deleted"`
+	Missing []int `json:"missing"`
+}
+
+// List users
+// ListUsers                godoc
+// @Summary      List users
+// @Description  Responds with every user. Admins only.
+// @Tags         admin
+// @Produce      json
+// @Success      200  {array}  models.User
+// @Security     BearerAuth
+// @Router       /admin/users [get]
+func ListUsers(c *gin.Context) {
+	users, err := models.ListUsers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": users})
+}
+
+// Update a user
+// UpdateUser                godoc
+// @Summary      Change the role of a user or disable them
+// @Description  Sets the role (admin, editor or viewer) and disabled flag of the user whose ID value matches the user_id. Admins only, and not for their own account.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        user_id  path  int              true  "user ID"
+// @Param        user     body  UpdateUserInput  true  "role and disabled flag"
+// @Success      200  {object}  models.User
+// @Security     BearerAuth
+// @Router       /admin/users/{user_id} [patch]
+func UpdateUser(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("user_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found!"})
+		return
+	}
+
+	var input UpdateUserInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.Role != "" && !models.ValidRole(input.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "role must be admin, editor or viewer"})
+		return
+	}
+
+	// Keeps admins from locking themselves, and possibly everyone, out.
+	if uint(id) == currentUserID(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You may not change your own role or disable yourself"})
+		return
+	}
+
+	user, err := models.UpdateUser(uint(id), input.Role, input.Disabled)
+	if errors.Is(err, models.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found!"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": user})
+}
+
+// Delete images in bulk
+// BulkDeleteImages                godoc
+// @Summary      Delete many images
+// @Description  Deletes the images whose IDs are listed, whoever owns them. Admins only.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        images  body  BulkImagesInput  true  "image IDs"
+// @Success      200  {object}  BulkDeleteResult
+// @Security     BearerAuth
+// @Router       /admin/images/delete [post]
+func (ic *ImageController) BulkDeleteImages(c *gin.Context) {
+	var input BulkImagesInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(input.ImageIDs) > maxBulkImages {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("imageids may hold at most %d IDs", maxBulkImages)})
+		return
+	}
+
+	result := BulkDeleteResult{Deleted: []int{}, Missing: []int{}}
+	for _, id := range input.ImageIDs {
+		err := ic.Store.Delete(id)
+		if err == models.ErrImageNotFound {
+			result.Missing = append(result.Missing, id)
+			continue
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "data": result})
+			return
+		}
+
+		removeThumbnails(id)
+		result.Deleted = append(result.Deleted, id)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+// Reindex images
+// ReindexImages                godoc
+// @Summary      Reindex images
+// @Description  Queues a job that reads the files of the listed images, or of every image, again and stores fresh metadata and thumbnails. Admins only.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        images  body  ReindexInput  false  "image IDs"
+// @Success      202  {object}  JobResponse
+// @Security     BearerAuth
+// @Router       /admin/images/reindex [post]
+func ReindexImages(c *gin.Context) {
+	var input ReindexInput
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	job, err := EnqueueJob(jobReindexImages, currentUserID(c), reindexPayload{ImageIDs: input.ImageIDs})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("/jobs/%d", job.JobID))
+	c.JSON(http.StatusAccepted, gin.H{"data": newJobResponse(job)})
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"imageApi/models"
+	token "imageApi/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Keys are listed by their first apiKeyPrefixLength characters.
+const apiKeyPrefixLength = 12
+
+type CreateAPIKeyInput struct {
+	Name   string   `json:"name" binding:"required,max=255"`
+	Scopes []string `json:"scopes" binding:"required,min=1"`
+}
+
+// CreatedAPIKey is a new API key. Key is only ever shown in this response.
+type CreatedAPIKey struct {
+	models.APIKey
+	Key string
+}
+
+// Create an API key
+// CreateAPIKey                godoc
+// @Summary      Create an API key
+// @Description  Creates an API key for the current user with the given scopes (images:read, images:write). The key is only shown in this response; send it in the X-API-Key header.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        key  body  CreateAPIKeyInput  true  "name and scopes"
+// @Success      201  {object}  CreatedAPIKey
+// @Security     BearerAuth
+// @Router       /auth/api-keys [post]
+func CreateAPIKey(c *gin.Context) {
+	var input CreateAPIKeyInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, scope := range input.Scopes {
+		if !models.ValidScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown scope %q, scopes are %s and %s", scope, models.ScopeImagesRead, models.ScopeImagesWrite)})
+			return
+		}
+	}
+
+	key, keyHash, err := token.GenerateAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	apiKey, err := models.CreateAPIKey(currentUserID(c), input.Name, key[:apiKeyPrefixLength], keyHash, input.Scopes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": CreatedAPIKey{APIKey: apiKey, Key: key}})
+}
+
+// List API keys
+// ListAPIKeys                godoc
+// @Summary      List API keys
+// @Description  Responds with the current user's API keys, without the keys themselves.
+// @Tags         auth
+// @Produce      json
+// @Success      200  {array}  models.APIKey
+// @Security     BearerAuth
+// @Router       /auth/api-keys [get]
+func ListAPIKeys(c *gin.Context) {
+	keys, err := models.ListAPIKeys(currentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": keys})
+}
+
+// Revoke an API key
+// RevokeAPIKey                godoc
+// @Summary      Revoke an API key
+// @Description  Revokes the current user's API key whose ID value matches the key_id.
+// @Tags         auth
+// @Produce      json
+// @Param        key_id  path  int  true  "API key ID"
+// @Success      200  {object}  models.APIKey
+// @Security     BearerAuth
+// @Router       /auth/api-keys/{key_id} [delete]
+func RevokeAPIKey(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("key_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found!"})
+		return
+	}
+
+	key, err := models.RevokeAPIKey(currentUserID(c), uint(id))
+	if errors.Is(err, models.ErrAPIKeyNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found!"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": key})
+}
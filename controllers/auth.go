@@ -0,0 +1,291 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"imageApi/models"
+	token "imageApi/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// New users get the DEFAULT_USER_ROLE role, read only viewer by default.
+// The first admin is created with the "user add" command, and admins give
+// users more rights through PATCH /admin/users/{user_id}.
+const defaultUserRole = models.RoleViewer
+
+func newUserRole() (string, error) {
+	role := getEnv("DEFAULT_USER_ROLE", defaultUserRole)
+	if !models.ValidRole(role) || role == models.RoleAdmin {
+		return "", fmt.Errorf("DEFAULT_USER_ROLE must be editor or viewer")
+	}
+	return role, nil
+}
+
+type RegisterInput struct {
+	Username string `json:"username" binding:"required,max=255"`
+	Password string `json:"password" binding:"required,min=8,max=72"`
+}
+
+type LoginInput struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type RefreshInput struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// LogoutInput optionally names the refresh token to revoke with the access
+// token, or asks for every refresh token of the user to be revoked.
+type LogoutInput struct {
+	RefreshToken string `json:"refresh_token"`
+	All          bool   `json:"all"`
+}
+
+// TokenResponse is a new short-lived access token and the refresh token to
+// get the next one with.
+type TokenResponse struct {
+	Token        string `json:"token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Register a user
+// Register                godoc
+// @Summary      Register a user
+// @Description  Creates a user account. Passwords are stored as bcrypt hashes. New users get the DEFAULT_USER_ROLE role.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        user  body  RegisterInput  true  "username and password"
+// @Success      201  {object}  models.User
+// @Router       /auth/register [post]
+func Register(c *gin.Context) {
+	var input RegisterInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role, err := newUserRole()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := models.CreateUser(input.Username, input.Password, role)
+	if errors.Is(err, models.ErrUserExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": user})
+}
+
+// Log in
+// Login                godoc
+// @Summary      Log in
+// @Description  Checks a username and password and responds with a short-lived bearer token and a refresh token for the user.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        credentials  body  LoginInput  true  "username and password"
+// @Success      200  {object}  TokenResponse
+// @Router       /auth/login [post]
+func Login(c *gin.Context) {
+	var input LoginInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := models.LoginCheck(input.Username, input.Password)
+	if errors.Is(err, models.ErrInvalidCredentials) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, models.ErrUserDisabled) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	refreshToken, refreshHash, err := token.GenerateRefreshToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Each login starts a new refresh token family, named after its first
+	// token.
+	err = models.CreateRefreshToken(user.UserID, refreshHash, refreshHash, time.Now().Add(token.RefreshTokenLifespan()))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	respondTokens(c, user, refreshToken)
+}
+
+// Refresh tokens
+// RefreshToken                godoc
+// @Summary      Refresh the access token
+// @Description  Trades a refresh token for a new access token and a new refresh token. The old refresh token stops working, and presenting it again revokes every token issued from the same login.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        token  body  RefreshInput  true  "refresh token"
+// @Success      200  {object}  TokenResponse
+// @Router       /auth/refresh [post]
+func RefreshToken(c *gin.Context) {
+	var input RefreshInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	refreshToken, refreshHash, err := token.GenerateRefreshToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := models.RotateRefreshToken(token.HashRefreshToken(input.RefreshToken), refreshHash, time.Now().Add(token.RefreshTokenLifespan()))
+	if errors.Is(err, models.ErrInvalidRefreshToken) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, models.ErrUserDisabled) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	respondTokens(c, user, refreshToken)
+}
+
+// Log out
+// Logout                godoc
+// @Summary      Log out
+// @Description  Revokes the bearer token and, when given, the refresh token, or with all set every refresh token of the user.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        token  body  LogoutInput  false  "refresh token to revoke"
+// @Success      200
+// @Security     BearerAuth
+// @Router       /auth/logout [post]
+func Logout(c *gin.Context) {
+	var input LogoutInput
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	jti, expiresAt, err := token.ExtractTokenJTI(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if jti != "" {
+		if err := models.RevokeTokenID(jti, expiresAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if input.RefreshToken != "" {
+		if err := models.RevokeRefreshToken(token.HashRefreshToken(input.RefreshToken)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if input.All {
+		if err := models.RevokeUserRefreshTokens(currentUserID(c)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": true})
+}
+
+// respondTokens writes a new access token for user along with refreshToken.
+func respondTokens(c *gin.Context, user models.User, refreshToken string) {
+	tokenString, err := token.GenerateToken(user.UserID, user.UserRole)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": TokenResponse{
+		Token:        tokenString,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(token.AccessTokenLifespan().Seconds()),
+		RefreshToken: refreshToken}})
+}
+
+// Get the current user
+// CurrentUser                godoc
+// @Summary      Get the current user
+// @Description  Responds with the user the bearer token was issued to.
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  models.User
+// @Router       /auth/me [get]
+func CurrentUser(c *gin.Context) {
+	userID, err := token.ExtractTokenID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := models.GetUserByID(userID)
+	if errors.Is(err, models.ErrUserNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": user})
+}
+
+// Get the token verification keys
+// JWKS                godoc
+// @Summary      Get the token verification keys
+// @Description  Responds with the public keys access tokens are signed with as a JSON Web Key Set, so other services can verify them.
+// @Tags         auth
+// @Produce      json
+// @Success      200  {object}  token.JWKSet
+// @Router       /.well-known/jwks.json [get]
+func JWKS(c *gin.Context) {
+	set, err := token.JWKS()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, set)
+}
@@ -0,0 +1,282 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"imageApi/middlewares"
+	"imageApi/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// useTestDB points models.DB at a migrated in-memory SQLite database for
+// the test.
+func useTestDB(t *testing.T) {
+	t.Helper()
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	db.DB().SetMaxOpenConns(1)
+	db.LogMode(false)
+
+	previous := models.DB
+	models.DB = db
+	t.Cleanup(func() {
+		models.DB = previous
+		db.Close()
+	})
+
+	if _, err := models.MigrateUp(); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	t.Setenv("API_SECRET", "test secret")
+}
+
+func newAuthRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	auth := middlewares.JwtAuthMiddleware()
+	r.POST("/auth/register", Register)
+	r.POST("/auth/login", Login)
+	r.POST("/auth/refresh", RefreshToken)
+	r.POST("/auth/logout", auth, Logout)
+	r.GET("/auth/me", auth, CurrentUser)
+	return r
+}
+
+// request sends body as JSON, with a bearer token when one is given, and
+// returns the status and the decoded "data" or "error" of the response.
+func request(r http.Handler, method string, path string, body interface{}, bearer string) (int, map[string]interface{}) {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if data, ok := response["data"].(map[string]interface{}); ok {
+		return w.Code, data
+	}
+	return w.Code, response
+}
+
+func credentials(username string, password string) gin.H {
+	return gin.H{"username": username, "password": password}
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	useTestDB(t)
+	r := newAuthRouter()
+
+	status, user := request(r, "POST", "/auth/register", credentials(" alice ", "password1"), "")
+	if status != http.StatusCreated {
+		t.Fatalf("register: status %d, %v", status, user)
+	}
+	if user["Username"] != "alice" || user["UserRole"] != models.RoleViewer {
+		t.Errorf("registered %v, want viewer alice", user)
+	}
+	if _, ok := user["PasswordHash"]; ok {
+		t.Error("register response has the password hash")
+	}
+
+	status, tokens := request(r, "POST", "/auth/login", credentials("alice", "password1"), "")
+	if status != http.StatusOK {
+		t.Fatalf("login: status %d, %v", status, tokens)
+	}
+	if tokens["token"] == "" || tokens["refresh_token"] == "" || tokens["token_type"] != "Bearer" {
+		t.Fatalf("login gave %v", tokens)
+	}
+
+	status, me := request(r, "GET", "/auth/me", nil, tokens["token"].(string))
+	if status != http.StatusOK || me["Username"] != "alice" {
+		t.Errorf("me: status %d, %v", status, me)
+	}
+}
+
+func TestRegisterRejected(t *testing.T) {
+	useTestDB(t)
+	r := newAuthRouter()
+
+	if status, body := request(r, "POST", "/auth/register", credentials("alice", "password1"), ""); status != http.StatusCreated {
+		t.Fatalf("register: status %d, %v", status, body)
+	}
+
+	tests := []struct {
+		name   string
+		body   gin.H
+		status int
+	}{
+		{"duplicate", credentials("alice", "password2"), http.StatusConflict},
+		{"duplicate with spaces", credentials("  alice", "password2"), http.StatusConflict},
+		{"short password", credentials("bob", "short"), http.StatusBadRequest},
+		{"no username", gin.H{"password": "password1"}, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if status, body := request(r, "POST", "/auth/register", test.body, ""); status != test.status {
+				t.Errorf("status %d, want %d: %v", status, test.status, body)
+			}
+		})
+	}
+}
+
+// Registering never makes an admin, whatever DEFAULT_USER_ROLE says.
+func TestRegisterRole(t *testing.T) {
+	useTestDB(t)
+	r := newAuthRouter()
+
+	t.Setenv("DEFAULT_USER_ROLE", models.RoleEditor)
+	if status, user := request(r, "POST", "/auth/register", credentials("alice", "password1"), ""); status != http.StatusCreated || user["UserRole"] != models.RoleEditor {
+		t.Errorf("with DEFAULT_USER_ROLE editor: status %d, %v", status, user)
+	}
+
+	t.Setenv("DEFAULT_USER_ROLE", models.RoleAdmin)
+	if status, user := request(r, "POST", "/auth/register", credentials("bob", "password1"), ""); status != http.StatusInternalServerError {
+		t.Errorf("with DEFAULT_USER_ROLE admin: status %d, %v", status, user)
+	}
+}
+
+func TestLoginRejected(t *testing.T) {
+	useTestDB(t)
+	r := newAuthRouter()
+
+	if _, err := models.CreateUser("alice", "password1", models.RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	disabled, err := models.CreateUser("mallory", "password1", models.RoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	yes := true
+	if _, err := models.UpdateUser(disabled.UserID, "", &yes); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		body   gin.H
+		status int
+	}{
+		{"bad password", credentials("alice", "password2"), http.StatusUnauthorized},
+		{"unknown user", credentials("bob", "password1"), http.StatusUnauthorized},
+		{"disabled user", credentials("mallory", "password1"), http.StatusForbidden},
+		{"no password", gin.H{"username": "alice"}, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, body := request(r, "POST", "/auth/login", test.body, "")
+			if status != test.status {
+				t.Errorf("status %d, want %d: %v", status, test.status, body)
+			}
+			if _, ok := body["token"]; ok {
+				t.Error("rejected login gave a token")
+			}
+		})
+	}
+}
+
+func login(t *testing.T, r http.Handler, username string) map[string]interface{} {
+	t.Helper()
+
+	status, tokens := request(r, "POST", "/auth/login", credentials(username, "password1"), "")
+	if status != http.StatusOK {
+		t.Fatalf("login: status %d, %v", status, tokens)
+	}
+	return tokens
+}
+
+func refresh(r http.Handler, refreshToken interface{}) (int, map[string]interface{}) {
+	return request(r, "POST", "/auth/refresh", gin.H{"refresh_token": refreshToken}, "")
+}
+
+// Presenting a refresh token that was already traded in must revoke every
+// token of its login, the current one included, but not other logins.
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	useTestDB(t)
+	r := newAuthRouter()
+	if _, err := models.CreateUser("alice", "password1", models.RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+
+	first := login(t, r, "alice")
+	other := login(t, r, "alice")
+
+	status, second := refresh(r, first["refresh_token"])
+	if status != http.StatusOK || second["refresh_token"] == first["refresh_token"] {
+		t.Fatalf("refresh: status %d, %v", status, second)
+	}
+
+	if status, body := refresh(r, first["refresh_token"]); status != http.StatusUnauthorized {
+		t.Fatalf("reused refresh token: status %d, %v", status, body)
+	}
+	if status, body := refresh(r, second["refresh_token"]); status != http.StatusUnauthorized {
+		t.Errorf("refresh token issued after the reused one: status %d, %v", status, body)
+	}
+	if status, body := refresh(r, other["refresh_token"]); status != http.StatusOK {
+		t.Errorf("refresh token of another login: status %d, %v", status, body)
+	}
+	if status, body := refresh(r, "made up"); status != http.StatusUnauthorized {
+		t.Errorf("unknown refresh token: status %d, %v", status, body)
+	}
+}
+
+// A logged out access token must be refused even though it has not expired,
+// and logging out revokes the refresh token given with it.
+func TestLogoutRevokesTokens(t *testing.T) {
+	useTestDB(t)
+	r := newAuthRouter()
+	if _, err := models.CreateUser("alice", "password1", models.RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := login(t, r, "alice")
+	other := login(t, r, "alice")
+	access := tokens["token"].(string)
+
+	if status, body := request(r, "POST", "/auth/logout", gin.H{"refresh_token": tokens["refresh_token"]}, access); status != http.StatusOK {
+		t.Fatalf("logout: status %d, %v", status, body)
+	}
+
+	if status, _ := request(r, "GET", "/auth/me", nil, access); status != http.StatusUnauthorized {
+		t.Errorf("me after logout: status %d, want 401", status)
+	}
+	if status, _ := request(r, "POST", "/auth/logout", nil, access); status != http.StatusUnauthorized {
+		t.Errorf("second logout: status %d, want 401", status)
+	}
+	if status, _ := refresh(r, tokens["refresh_token"]); status != http.StatusUnauthorized {
+		t.Errorf("refresh after logout: status %d, want 401", status)
+	}
+	if status, _ := request(r, "GET", "/auth/me", nil, other["token"].(string)); status != http.StatusOK {
+		t.Errorf("me with another login's token: status %d, want 200", status)
+	}
+}
+
+// Revoking a token ID twice, as two logouts at once do, is not an error.
+func TestRevokeTokenIDTwice(t *testing.T) {
+	useTestDB(t)
+
+	expiresAt := time.Now().Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		if err := models.RevokeTokenID("jti", expiresAt); err != nil {
+			t.Fatalf("RevokeTokenID %d: %v", i+1, err)
+		}
+	}
+	if revoked, err := models.IsTokenRevoked("jti"); err != nil || !revoked {
+		t.Errorf("IsTokenRevoked = %v, %v", revoked, err)
+	}
+}
@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"imageApi/models"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// captureTime is when an image was taken. Time is in the camera's time zone
+// when TimeZone, a UTC offset such as "+02:00", is known. Otherwise TimeZone
+// is models.TimeZoneLocal and Time holds the camera's local time as if it
+// were UTC.
+type captureTime struct {
+	Time     time.Time
+	Source   string
+	TimeZone string
+}
+
+// fileNameTimePattern finds the date and optional time that phones and
+// cameras put in file names, such as IMG_20230514_101112.jpg,
+// PXL_20230514_101112345.jpg, IMG-20230514-WA0001.jpg and
+// "Screenshot 2023-05-14 at 10.11.12.png".
+var fileNameTimePattern = regexp.MustCompile(
+	`(?:^|\D)((?:19|20)\d\d)[-_.]?(0[1-9]|1[0-2])[-_.]?(0[1-9]|[12]\d|3[01])` +
+		`(?:(?:[-_ T]|\sat\s)?([01]\d|2[0-3])[-_.:]?([0-5]\d)[-_.:]?([0-5]\d))?`)
+
+// findCaptureTime works out when an image was taken from its metadata
+// fields, trying in order:
+//
+//  1. DateTimeOriginal, with SubSecTimeOriginal and OffsetTimeOriginal
+//  2. CreateDate, with SubSecTimeDigitized and OffsetTimeDigitized
+//  3. the GPS date and time, which are in UTC
+//  4. the date time given by the caller
+//  5. a date in the file name, such as IMG_20230514_101112.jpg
+//  6. the file's modification time
+//
+// A date time without a UTC offset takes OffsetTime, and failing that the
+// offset between it and the GPS time when the two are within 14 hours. An
+// error is only returned for a caller date time that cannot be parsed.
+func findCaptureTime(fields map[string]interface{}, fileName string, dateTime string, modTime time.Time) (captureTime, error) {
+	gps, hasGPS := gpsDateTime(fields)
+
+	exifTags := []struct{ source, subSec, offset string }{
+		{models.TakenAtDateTimeOriginal, "SubSecTimeOriginal", "OffsetTimeOriginal"},
+		{models.TakenAtCreateDate, "SubSecTimeDigitized", "OffsetTimeDigitized"},
+	}
+	for _, tags := range exifTags {
+		t, hasZone, ok := parseExifDateTime(fields[tags.source], fields[tags.subSec])
+		if !ok {
+			continue
+		}
+		if !hasZone {
+			if zone, ok := exifTimeZone(fields[tags.offset], fields["OffsetTime"]); ok {
+				t, hasZone = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), zone), true
+			} else if hasGPS {
+				t, hasZone = gpsTimeZone(t, gps)
+			}
+		}
+		return newCaptureTime(t, tags.source, hasZone), nil
+	}
+
+	if hasGPS {
+		return newCaptureTime(gps, models.TakenAtGPSDateTime, true), nil
+	}
+
+	if dateTime = strings.TrimSpace(dateTime); dateTime != "" && !strings.HasPrefix(dateTime, "0000:00:00") {
+		captured, err := parseCaptureTime(dateTime)
+		if err != nil {
+			return captureTime{}, err
+		}
+		return captured, nil
+	}
+
+	if t, ok := fileNameTime(fileName); ok {
+		return newCaptureTime(t, models.TakenAtFileName, false), nil
+	}
+
+	return newCaptureTime(modTime.Truncate(time.Second), models.TakenAtFileModifyDate, true), nil
+}
+
+// parseCaptureTime reads a date time given by the caller, as
+// models.ParseImageDateTime does.
+func parseCaptureTime(dateTime string) (captureTime, error) {
+	t, hasZone, err := models.ParseImageDateTime(dateTime)
+	if err != nil {
+		return captureTime{}, err
+	}
+	return newCaptureTime(t, models.TakenAtImageDateTime, hasZone), nil
+}
+
+func newCaptureTime(t time.Time, source string, hasZone bool) captureTime {
+	return captureTime{Time: t, Source: source, TimeZone: models.CaptureTimeZone(t, hasZone)}
+}
+
+// takenAt returns the capture time in UTC, as ImageTakenAt stores it.
+func (captured captureTime) takenAt() *time.Time {
+	t := captured.Time.UTC()
+	return &t
+}
+
+// dateTime and date return the EXIF style ImageDateTime and the date parts,
+// which are in the camera's time zone.
+func (captured captureTime) dateTime() string {
+	return captured.Time.Format(exifDateTimeLayout)
+}
+
+func (captured captureTime) date() (int, int, int) {
+	return captured.Time.Year(), int(captured.Time.Month()), captured.Time.Day()
+}
+
+// parseExifDateTime reads an EXIF date time, adding the fractional seconds
+// of subSec when it has none of its own. Zeroed dates are not valid.
+func parseExifDateTime(value interface{}, subSec interface{}) (time.Time, bool, bool) {
+	if value == nil {
+		return time.Time{}, false, false
+	}
+	dateTime := strings.TrimSpace(fieldString(value))
+
+	if subSec != nil && len(dateTime) == len(exifDateTimeLayout) {
+		if digits := strings.TrimSpace(fieldString(subSec)); digits != "" {
+			if _, err := strconv.Atoi(digits); err == nil {
+				dateTime += "." + digits
+			}
+		}
+	}
+
+	return models.ParseExifDateTime(dateTime)
+}
+
+// exifTimeZone reads the first valid OffsetTime style value, such as
+// "+02:00".
+func exifTimeZone(values ...interface{}) (*time.Location, bool) {
+	for _, value := range values {
+		if value == nil {
+			continue
+		}
+		t, err := time.Parse("-07:00", strings.TrimSpace(fieldString(value)))
+		if err != nil {
+			continue
+		}
+		_, offset := t.Zone()
+		return time.FixedZone("", offset), true
+	}
+	return nil, false
+}
+
+// gpsDateTime reads the UTC date time of the GPS fix, from GPSDateTime when
+// exiftool gives it and otherwise from GPSDateStamp and GPSTimeStamp.
+func gpsDateTime(fields map[string]interface{}) (time.Time, bool) {
+	var dateTime string
+	if value, ok := fields["GPSDateTime"]; ok {
+		dateTime = strings.TrimSuffix(strings.TrimSpace(fieldString(value)), "Z")
+	} else {
+		date, hasDate := fields["GPSDateStamp"]
+		clock, hasClock := fields["GPSTimeStamp"]
+		if !hasDate || !hasClock {
+			return time.Time{}, false
+		}
+		dateTime = strings.TrimSpace(fieldString(date)) + " " + strings.TrimSpace(fieldString(clock))
+	}
+
+	t, err := time.Parse(exifDateTimeLayout, dateTime)
+	return t, err == nil
+}
+
+// gpsTimeZone places local, a camera time without a time zone, in the
+// zone its difference from the GPS time suggests, rounded to a quarter of
+// an hour. Times more than 14 hours apart are taken to be unrelated.
+func gpsTimeZone(local time.Time, gps time.Time) (time.Time, bool) {
+	difference := local.Sub(gps)
+	if math.Abs(difference.Hours()) > 14 {
+		return local, false
+	}
+
+	offset := int(difference.Round(15*time.Minute) / time.Second)
+	zoned := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(), local.Nanosecond(), time.FixedZone("", offset))
+	return zoned, true
+}
+
+// fileNameTime reads the date and time in a file name. A name with a date
+// but no time gives midnight.
+func fileNameTime(fileName string) (time.Time, bool) {
+	match := fileNameTimePattern.FindStringSubmatch(fileName)
+	if match == nil {
+		return time.Time{}, false
+	}
+
+	parts := make([]int, 6)
+	for i, part := range match[1:] {
+		parts[i], _ = strconv.Atoi(part)
+	}
+
+	t := time.Date(parts[0], time.Month(parts[1]), parts[2], parts[3], parts[4], parts[5], 0, time.UTC)
+	if t.Day() != parts[2] {
+		// A day past the end of its month, such as 20230231.
+		return time.Time{}, false
+	}
+	return t, true
+}
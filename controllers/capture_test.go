@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"imageApi/models"
+	"testing"
+	"time"
+)
+
+func TestFindCaptureTime(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 600, time.FixedZone("", -5*3600))
+
+	tests := []struct {
+		name     string
+		fields   map[string]interface{}
+		fileName string
+		dateTime string
+		// takenAt is the UTC time stored in ImageTakenAt and local the
+		// ImageDateTime in the camera's time zone.
+		takenAt  string
+		local    string
+		source   string
+		timeZone string
+	}{
+		{
+			name: "DateTimeOriginal with its offset",
+			fields: map[string]interface{}{
+				"DateTimeOriginal":   "2023:05:14 10:11:12",
+				"OffsetTimeOriginal": "+02:00",
+				"OffsetTime":         "-07:00",
+				"CreateDate":         "2023:05:14 11:11:12"},
+			fileName: "IMG_20200101_000000.jpg",
+			dateTime: "2021:01:01 00:00:00",
+			takenAt:  "2023-05-14T08:11:12Z",
+			local:    "2023:05:14 10:11:12",
+			source:   models.TakenAtDateTimeOriginal,
+			timeZone: "+02:00",
+		},
+		{
+			name: "offset in the date time wins over OffsetTimeOriginal",
+			fields: map[string]interface{}{
+				"DateTimeOriginal":   "2023:05:14 10:11:12+05:30",
+				"OffsetTimeOriginal": "+02:00"},
+			takenAt:  "2023-05-14T04:41:12Z",
+			local:    "2023:05:14 10:11:12",
+			source:   models.TakenAtDateTimeOriginal,
+			timeZone: "+05:30",
+		},
+		{
+			name: "OffsetTime when there is no OffsetTimeOriginal",
+			fields: map[string]interface{}{
+				"DateTimeOriginal": "2023:05:14 10:11:12",
+				"OffsetTime":       "-04:00"},
+			takenAt:  "2023-05-14T14:11:12Z",
+			local:    "2023:05:14 10:11:12",
+			source:   models.TakenAtDateTimeOriginal,
+			timeZone: "-04:00",
+		},
+		{
+			name: "sub-seconds",
+			fields: map[string]interface{}{
+				"DateTimeOriginal":   "2023:05:14 10:11:12",
+				"SubSecTimeOriginal": "25",
+				"OffsetTimeOriginal": "+00:00"},
+			takenAt:  "2023-05-14T10:11:12.25Z",
+			local:    "2023:05:14 10:11:12",
+			source:   models.TakenAtDateTimeOriginal,
+			timeZone: "+00:00",
+		},
+		{
+			name: "offset from the GPS time",
+			fields: map[string]interface{}{
+				"DateTimeOriginal": "2023:05:14 10:11:12",
+				"GPSDateTime":      "2023:05:14 08:40:00Z"},
+			takenAt:  "2023-05-14T08:41:12Z",
+			local:    "2023:05:14 10:11:12",
+			source:   models.TakenAtDateTimeOriginal,
+			timeZone: "+01:30",
+		},
+		{
+			name: "offset from the GPS date and time stamps",
+			fields: map[string]interface{}{
+				"DateTimeOriginal": "2023:05:14 01:00:00",
+				"GPSDateStamp":     "2023:05:14",
+				"GPSTimeStamp":     "09:00:00"},
+			takenAt:  "2023-05-14T09:00:00Z",
+			local:    "2023:05:14 01:00:00",
+			source:   models.TakenAtDateTimeOriginal,
+			timeZone: "-08:00",
+		},
+		{
+			name: "GPS time too far off to give an offset",
+			fields: map[string]interface{}{
+				"DateTimeOriginal": "2023:05:14 10:11:12",
+				"GPSDateTime":      "2023:05:15 08:00:00Z"},
+			takenAt:  "2023-05-14T10:11:12Z",
+			local:    "2023:05:14 10:11:12",
+			source:   models.TakenAtDateTimeOriginal,
+			timeZone: models.TimeZoneLocal,
+		},
+		{
+			name: "CreateDate when DateTimeOriginal is zeroed",
+			fields: map[string]interface{}{
+				"DateTimeOriginal":    "0000:00:00 00:00:00",
+				"CreateDate":          "2022:12:31 23:59:59",
+				"OffsetTimeDigitized": "+09:00"},
+			takenAt:  "2022-12-31T14:59:59Z",
+			local:    "2022:12:31 23:59:59",
+			source:   models.TakenAtCreateDate,
+			timeZone: "+09:00",
+		},
+		{
+			name: "GPS time when there is no camera time",
+			fields: map[string]interface{}{
+				"GPSDateTime": "2023:05:14 08:11:12Z"},
+			fileName: "IMG_20200101_000000.jpg",
+			takenAt:  "2023-05-14T08:11:12Z",
+			local:    "2023:05:14 08:11:12",
+			source:   models.TakenAtGPSDateTime,
+			timeZone: "+00:00",
+		},
+		{
+			name:     "caller's date time before the file name",
+			fileName: "PXL_20230514_101112345.jpg",
+			dateTime: "2021:01:01 00:00:00",
+			takenAt:  "2021-01-01T00:00:00Z",
+			local:    "2021:01:01 00:00:00",
+			source:   models.TakenAtImageDateTime,
+			timeZone: models.TimeZoneLocal,
+		},
+		{
+			name:     "file name when the caller's date time is zeroed",
+			fileName: "PXL_20230514_101112345.jpg",
+			dateTime: "0000:00:00 00:00:00",
+			takenAt:  "2023-05-14T10:11:12Z",
+			local:    "2023:05:14 10:11:12",
+			source:   models.TakenAtFileName,
+			timeZone: models.TimeZoneLocal,
+		},
+		{
+			name:     "file name with a date only",
+			fileName: "IMG-20230514-WA0001.jpg",
+			takenAt:  "2023-05-14T00:00:00Z",
+			local:    "2023:05:14 00:00:00",
+			source:   models.TakenAtFileName,
+			timeZone: models.TimeZoneLocal,
+		},
+		{
+			name:     "caller's EXIF date time",
+			fileName: "holiday.jpg",
+			dateTime: "2021:06:07 08:09:10",
+			takenAt:  "2021-06-07T08:09:10Z",
+			local:    "2021:06:07 08:09:10",
+			source:   models.TakenAtImageDateTime,
+			timeZone: models.TimeZoneLocal,
+		},
+		{
+			name:     "caller's RFC 3339 time",
+			fileName: "holiday.jpg",
+			dateTime: "2021-06-07T08:09:10-03:00",
+			takenAt:  "2021-06-07T11:09:10Z",
+			local:    "2021:06:07 08:09:10",
+			source:   models.TakenAtImageDateTime,
+			timeZone: "-03:00",
+		},
+		{
+			name:     "file modification time",
+			fileName: "holiday.jpg",
+			dateTime: "0000:00:00 00:00:00",
+			takenAt:  "2024-01-02T08:04:05Z",
+			local:    "2024:01:02 03:04:05",
+			source:   models.TakenAtFileModifyDate,
+			timeZone: "-05:00",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			captured, err := findCaptureTime(test.fields, test.fileName, test.dateTime, modTime)
+			if err != nil {
+				t.Fatalf("findCaptureTime: %v", err)
+			}
+
+			if got := captured.takenAt().Format(time.RFC3339Nano); got != test.takenAt {
+				t.Errorf("takenAt = %s, want %s", got, test.takenAt)
+			}
+			if got := captured.dateTime(); got != test.local {
+				t.Errorf("dateTime = %s, want %s", got, test.local)
+			}
+			if captured.Source != test.source {
+				t.Errorf("Source = %s, want %s", captured.Source, test.source)
+			}
+			if captured.TimeZone != test.timeZone {
+				t.Errorf("TimeZone = %s, want %s", captured.TimeZone, test.timeZone)
+			}
+		})
+	}
+}
+
+func TestFindCaptureTimeBadDateTime(t *testing.T) {
+	if _, err := findCaptureTime(nil, "holiday.jpg", "next tuesday", time.Now()); err == nil {
+		t.Error("findCaptureTime accepted an unparsable date time")
+	}
+}
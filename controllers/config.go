@@ -0,0 +1,25 @@
+package controllers
+
+import (
+	"os"
+	"strconv"
+)
+
+// getEnv returns the value of the environment variable key, or def when it
+// is unset or empty.
+func getEnv(key string, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// getEnvInt returns the environment variable key parsed as an integer, or
+// def when it is unset or not a number.
+func getEnvInt(key string, def int64) int64 {
+	value, err := strconv.ParseInt(os.Getenv(key), 10, 64)
+	if err != nil {
+		return def
+	}
+	return value
+}
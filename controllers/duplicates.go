@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"fmt"
+	"imageApi/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// What to do when a new image has the same contents as one the owner can
+// already see. Reject fails with ErrCodeDuplicate, link responds with the
+// existing image instead of storing a new one and force stores it anyway.
+const (
+	onDuplicateReject = "reject"
+	onDuplicateLink   = "link"
+	onDuplicateForce  = "force"
+)
+
+func validOnDuplicate(onDuplicate string) bool {
+	return onDuplicate == onDuplicateReject || onDuplicate == onDuplicateLink || onDuplicate == onDuplicateForce
+}
+
+// parseOnDuplicate reads the on_duplicate query parameter, which defaults to
+// reject.
+func parseOnDuplicate(c *gin.Context) (string, error) {
+	onDuplicate := c.DefaultQuery("on_duplicate", onDuplicateReject)
+	if !validOnDuplicate(onDuplicate) {
+		return "", fmt.Errorf("on_duplicate must be reject, link or force")
+	}
+	return onDuplicate, nil
+}
+
+// DuplicateError is wrapped in an ImageError with ErrCodeDuplicate when a
+// new image is rejected for having the same contents as an existing one.
+type DuplicateError struct {
+	ImageID int
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("same contents as image %d", e.ImageID)
+}
+
+// ownerAccess scopes the duplicate check to the images ownerID can see. Images
+// without an owner come from the command line, which sees everything.
+func ownerAccess(ownerID uint) *models.ImageAccess {
+	if ownerID == 0 {
+		return nil
+	}
+	return &models.ImageAccess{UserID: ownerID}
+}
+
+// checkDuplicate applies the on_duplicate policy to a processed image that is
+// about to be stored. It returns the existing image when the new one should
+// be linked to it instead, and an error when it should be rejected.
+func (ic *ImageController) checkDuplicate(image models.Image, onDuplicate string, access *models.ImageAccess) (*models.Image, error) {
+	if onDuplicate == onDuplicateForce || image.ImageHash == "" {
+		return nil, nil
+	}
+
+	existing, _, err := ic.Store.Search(models.ImageQuery{
+		Filter: models.ImageFilter{Hash: image.ImageHash, Access: access},
+		Sort:   "image_id",
+		Limit:  1})
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) == 0 {
+		return nil, nil
+	}
+
+	if onDuplicate == onDuplicateLink {
+		return &existing[0], nil
+	}
+	return nil, newImageError(ErrCodeDuplicate, image.ImageDirLocation, &DuplicateError{ImageID: existing[0].ImageID})
+}
+
+// List duplicate images
+// FindDuplicates             godoc
+// @Summary      List groups of duplicate images
+// @Description  Responds with the groups of visible images that have identical file contents, largest group first. Takes the same filters as /images.
+// @Tags         images
+// @Produce      json
+// @Param        limit  query  int  false  "groups per page (default 50, max 500)"
+// @Param        page   query  int  false  "page number"
+// @Success      200  {array}  models.DuplicateGroup
+// @Router       /images/duplicates [get]
+func (ic *ImageController) FindDuplicates(c *gin.Context) {
+	list, err := parseImageList(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query := list.Query
+	if query.Cursor != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cursor is not supported for duplicates"})
+		return
+	}
+
+	groups, total, err := ic.Store.Duplicates(query.Filter, query.Limit, query.Offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  groups,
+		"total": total,
+		"limit": query.Limit,
+		"page":  query.Offset/query.Limit + 1})
+}
@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"errors"
+	"imageApi/models"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newDuplicateStore returns a memory store holding the images, with IDs
+// from 1 in order.
+func newDuplicateStore(t *testing.T, images ...models.Image) *models.MemoryImageStore {
+	t.Helper()
+
+	store := models.NewMemoryImageStore()
+	for _, image := range images {
+		if err := store.Create(&image); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func TestCheckDuplicate(t *testing.T) {
+	ic := &ImageController{Store: newDuplicateStore(t,
+		models.Image{ImageFileName: "1.jpg", ImageHash: "a", ImageOwnerID: 1, ImageVisibility: models.VisibilityPrivate},
+		models.Image{ImageFileName: "2.jpg", ImageHash: "b", ImageOwnerID: 2, ImageVisibility: models.VisibilityPrivate},
+		models.Image{ImageFileName: "3.jpg", ImageHash: "c", ImageOwnerID: 2, ImageVisibility: models.VisibilityPublic},
+		models.Image{ImageFileName: "4.jpg", ImageHash: "c", ImageOwnerID: 1, ImageVisibility: models.VisibilityPrivate})}
+
+	tests := []struct {
+		name        string
+		hash        string
+		onDuplicate string
+		access      *models.ImageAccess
+		// linked is the ID of the image to link to and rejected the ID
+		// named by the duplicate error.
+		linked   int
+		rejected int
+	}{
+		{"reject", "a", onDuplicateReject, ownerAccess(1), 0, 1},
+		{"link", "a", onDuplicateLink, ownerAccess(1), 1, 0},
+		{"force", "a", onDuplicateForce, ownerAccess(1), 0, 0},
+		{"new contents", "d", onDuplicateReject, ownerAccess(1), 0, 0},
+		{"no hash", "", onDuplicateReject, ownerAccess(1), 0, 0},
+		{"another user's private image", "b", onDuplicateReject, ownerAccess(1), 0, 0},
+		{"another user's public image", "b", onDuplicateLink, ownerAccess(2), 2, 0},
+		{"oldest of several", "c", onDuplicateLink, ownerAccess(1), 3, 0},
+		{"reject the oldest", "c", onDuplicateReject, ownerAccess(1), 0, 3},
+		{"everything visible", "b", onDuplicateReject, ownerAccess(0), 0, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			image := models.Image{ImageDirLocation: "/library/new.jpg", ImageHash: test.hash}
+			existing, err := ic.checkDuplicate(image, test.onDuplicate, test.access)
+
+			var duplicate *DuplicateError
+			switch {
+			case test.rejected != 0:
+				if !errors.As(err, &duplicate) || duplicate.ImageID != test.rejected || errorCode(err) != ErrCodeDuplicate {
+					t.Errorf("error %v, want a duplicate of image %d", err, test.rejected)
+				}
+			case err != nil:
+				t.Errorf("error %v", err)
+			}
+
+			linked := 0
+			if existing != nil {
+				linked = existing.ImageID
+			}
+			if linked != test.linked {
+				t.Errorf("linked to image %d, want %d", linked, test.linked)
+			}
+		})
+	}
+}
+
+// asUser authenticates every request as userID with role, as the auth
+// middleware would.
+func asUser(userID uint, role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("user_id", userID)
+		c.Set("role", role)
+	}
+}
+
+// duplicateGroups returns the IDs of each group's images.
+func duplicateGroups(response map[string]interface{}) [][]int {
+	groups := [][]int{}
+	data, _ := response["data"].([]interface{})
+	for _, group := range data {
+		ids := []int{}
+		images, _ := group.(map[string]interface{})["Images"].([]interface{})
+		for _, image := range images {
+			ids = append(ids, int(image.(map[string]interface{})["ImageID"].(float64)))
+		}
+		groups = append(groups, ids)
+	}
+	return groups
+}
+
+func TestFindDuplicates(t *testing.T) {
+	ic := &ImageController{Store: newDuplicateStore(t,
+		models.Image{ImageFileName: "1.jpg", ImageWidth: 200, ImageHash: "a", ImageOwnerID: 1, ImageVisibility: models.VisibilityPublic},
+		models.Image{ImageFileName: "2.jpg", ImageWidth: 200, ImageHash: "a", ImageOwnerID: 1, ImageVisibility: models.VisibilityPrivate},
+		models.Image{ImageFileName: "3.jpg", ImageHash: "a", ImageOwnerID: 2, ImageVisibility: models.VisibilityPrivate},
+		models.Image{ImageFileName: "4.jpg", ImageWidth: 200, ImageHash: "b", ImageOwnerID: 1, ImageVisibility: models.VisibilityPrivate},
+		models.Image{ImageFileName: "5.jpg", ImageHash: "b", ImageOwnerID: 1, ImageVisibility: models.VisibilityPrivate},
+		models.Image{ImageFileName: "6.jpg", ImageHash: "c", ImageOwnerID: 1, ImageVisibility: models.VisibilityPublic},
+		models.Image{ImageFileName: "7.jpg", ImageOwnerID: 1, ImageVisibility: models.VisibilityPublic},
+		models.Image{ImageFileName: "8.jpg", ImageOwnerID: 1, ImageVisibility: models.VisibilityPublic})}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/admin/duplicates", asUser(9, models.RoleAdmin), ic.FindDuplicates)
+	r.GET("/1/duplicates", asUser(1, models.RoleEditor), ic.FindDuplicates)
+	r.GET("/2/duplicates", asUser(2, models.RoleEditor), ic.FindDuplicates)
+
+	tests := []struct {
+		name   string
+		path   string
+		groups [][]int
+		total  int
+	}{
+		// Largest group first, and images without a hash are never
+		// duplicates.
+		{"admin", "/admin/duplicates", [][]int{{1, 2, 3}, {4, 5}}, 2},
+		{"owner", "/1/duplicates", [][]int{{1, 2}, {4, 5}}, 2},
+		{"public image of another user", "/2/duplicates", [][]int{{1, 3}}, 1},
+		{"page", "/admin/duplicates?limit=1&page=2", [][]int{{4, 5}}, 2},
+		{"past the last page", "/admin/duplicates?limit=1&page=3", [][]int{}, 2},
+		// Only the images that match the filter are grouped.
+		{"filter", "/admin/duplicates?width_min=100", [][]int{{1, 2}}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, response := request(r, "GET", test.path, nil, "")
+			if status != http.StatusOK {
+				t.Fatalf("status %d: %v", status, response)
+			}
+			if groups := duplicateGroups(response); !reflect.DeepEqual(groups, test.groups) {
+				t.Errorf("groups %v, want %v", groups, test.groups)
+			}
+			if total := response["total"]; total != float64(test.total) {
+				t.Errorf("total %v, want %d", total, test.total)
+			}
+		})
+	}
+
+	for _, path := range []string{"/admin/duplicates?limit=0", "/admin/duplicates?cursor=abc"} {
+		if status, _ := request(r, "GET", path, nil, ""); status != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", path, status, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Machine readable codes sent in the "code" field of error responses.
+const (
+	ErrCodeFileNotFound   = "file_not_found"
+	ErrCodeFileUnreadable = "file_unreadable"
+	ErrCodeNotImage       = "not_an_image"
+	ErrCodeExifFailed     = "exif_failed"
+	ErrCodeInvalidDate    = "invalid_date"
+	ErrCodeUnsupported    = "unsupported_image"
+	ErrCodeDuplicate      = "duplicate_image"
+	ErrCodePathNotAllowed = "path_not_allowed"
+	ErrCodeNotOwner       = "not_owner"
+	ErrCodeInternal       = "internal_error"
+)
+
+// ImageError is returned when an image file cannot be processed. Code tells
+// callers what went wrong without having to parse the message.
+type ImageError struct {
+	Code string
+	Path string
+	Err  error
+}
+
+func (e *ImageError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *ImageError) Unwrap() error {
+	return e.Err
+}
+
+func newImageError(code string, path string, err error) *ImageError {
+	return &ImageError{Code: code, Path: path, Err: err}
+}
+
+// errorCode returns the code for err, or ErrCodeInternal when it is not an
+// ImageError.
+func errorCode(err error) string {
+	var imageErr *ImageError
+	if errors.As(err, &imageErr) {
+		return imageErr.Code
+	}
+	return ErrCodeInternal
+}
+
+// errorStatus maps an error code to its HTTP status.
+func errorStatus(code string) int {
+	switch code {
+	case ErrCodeFileNotFound:
+		return http.StatusNotFound
+	case ErrCodeFileUnreadable, ErrCodeInvalidDate:
+		return http.StatusUnprocessableEntity
+	case ErrCodeNotImage, ErrCodeUnsupported:
+		return http.StatusUnsupportedMediaType
+	case ErrCodeDuplicate:
+		return http.StatusConflict
+	case ErrCodePathNotAllowed, ErrCodeNotOwner:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// respondError writes err as a JSON error response with its code. A rejected
+// duplicate also names the image it duplicates.
+func respondError(c *gin.Context, err error) {
+	code := errorCode(err)
+	response := gin.H{"error": err.Error(), "code": code}
+
+	var duplicateErr *DuplicateError
+	if errors.As(err, &duplicateErr) {
+		response["duplicate_of"] = duplicateErr.ImageID
+	}
+
+	c.JSON(errorStatus(code), response)
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fileHash is a cached content hash, valid while the file keeps its size
+// and modification time.
+type fileHash struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+var fileHashes sync.Map
+
+// Download an image
+// DownloadImage                godoc
+// @Summary      Get the original file of an image
+// @Description  Streams the original file of the image whose ID value matches the image_id. Supports Range requests and conditional GETs through ETag and Last-Modified. Files outside the image library are not served.
+// @Tags         images
+// @Produce      octet-stream
+// @Param        image_id  path   int   true   "image ID"
+// @Param        download  query  bool  false  "send as an attachment"
+// @Success      200
+// @Success      206
+// @Success      304
+// @Router       /images/{image_id}/file [get]
+func (ic *ImageController) DownloadImage(c *gin.Context) {
+	image, ok := ic.findImage(c)
+	if !ok {
+		return
+	}
+
+	// Only files in the library are served, and files elsewhere get the
+	// same error whether they exist or not.
+	path, err := resolveLibraryPath(image.ImageDirLocation)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		respondError(c, newImageError(ErrCodeFileNotFound, image.ImageDirLocation, err))
+		return
+	}
+	if err != nil {
+		respondError(c, newImageError(ErrCodeFileUnreadable, image.ImageDirLocation, err))
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		respondError(c, newImageError(ErrCodeFileUnreadable, image.ImageDirLocation, err))
+		return
+	}
+
+	hash, err := contentHash(file, info)
+	if err != nil {
+		respondError(c, newImageError(ErrCodeFileUnreadable, image.ImageDirLocation, err))
+		return
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(image.ImageFileName))
+	if contentType == "" {
+		buf := make([]byte, 512)
+		n, _ := file.ReadAt(buf, 0)
+		contentType = http.DetectContentType(buf[:n])
+	}
+
+	disposition := "inline"
+	if c.Query("download") == "true" || c.Query("download") == "1" {
+		disposition = "attachment"
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": image.ImageFileName}))
+	c.Header("ETag", `"`+hash+`"`)
+
+	// ServeContent handles Range, If-Range, If-None-Match and
+	// If-Modified-Since.
+	http.ServeContent(c.Writer, c.Request, image.ImageFileName, info.ModTime(), file)
+}
+
+// contentHash returns the hex SHA-256 of file, reusing the last result while
+// the file's size and modification time are unchanged.
+func contentHash(file *os.File, info os.FileInfo) (string, error) {
+	if cached, ok := fileHashes.Load(file.Name()); ok {
+		cached := cached.(fileHash)
+		if cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+			return cached.hash, nil
+		}
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(file, 0, info.Size())); err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	fileHashes.Store(file.Name(), fileHash{size: info.Size(), modTime: info.ModTime(), hash: hash})
+	return hash, nil
+}
@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"fmt"
+	"imageApi/models"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GET /images/near returns at most maxNearLimit images.
+const (
+	defaultNearLimit = 50
+	maxNearLimit     = 500
+	maxRadiusKm      = 20000
+)
+
+// NearbyImage is an image with its distance from the searched point.
+type NearbyImage struct {
+	models.Image
+	DistanceKm float64
+}
+
+// Find images near a point
+// NearImages             godoc
+// @Summary      Get images near a point
+// @Description  Responds with the images taken within radius_km of lat/lon, nearest first.
+// @Tags         images
+// @Produce      json
+// @Param        lat        query  number  true   "latitude in decimal degrees"
+// @Param        lon        query  number  true   "longitude in decimal degrees"
+// @Param        radius_km  query  number  true   "search radius in kilometres"
+// @Param        limit      query  int     false  "maximum number of images (default 50, max 500)"
+// @Success      200  {array}  NearbyImage
+// @Router       /images/near [get]
+func (ic *ImageController) NearImages(c *gin.Context) {
+	lat, err := strconv.ParseFloat(c.Query("lat"), 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "lat must be a number"})
+		return
+	}
+
+	lon, err := strconv.ParseFloat(c.Query("lon"), 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "lon must be a number"})
+		return
+	}
+
+	if !models.ValidCoordinates(lat, lon) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "lat must be within ±90 and lon within ±180"})
+		return
+	}
+
+	radius, err := strconv.ParseFloat(c.Query("radius_km"), 64)
+	if err != nil || radius <= 0 || radius > maxRadiusKm {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("radius_km must be a number between 0 and %d", maxRadiusKm)})
+		return
+	}
+
+	limit := defaultNearLimit
+	if value := c.Query("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxNearLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxNearLimit)})
+			return
+		}
+	}
+
+	// The bounding box narrows the search in the database, then the exact
+	// distance drops the corners.
+	bounds := models.RadiusBounds(lat, lon, radius)
+	candidates, _, err := ic.Store.Search(models.ImageQuery{Filter: models.ImageFilter{Bounds: &bounds, Access: imageAccess(c)}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	images := []NearbyImage{}
+	for _, image := range candidates {
+		distance := models.DistanceKm(lat, lon, *image.ImageLatitude, *image.ImageLongitude)
+		if distance <= radius {
+			images = append(images, NearbyImage{Image: image, DistanceKm: distance})
+		}
+	}
+
+	sort.SliceStable(images, func(i, j int) bool {
+		return images[i].DistanceKm < images[j].DistanceKm
+	})
+
+	total := len(images)
+	if len(images) > limit {
+		images = images[:limit]
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": images, "total": total, "limit": limit})
+}
+
+// Find images in a bounding box
+// WithinImages             godoc
+// @Summary      Get images inside a bounding box
+// @Description  Responds with a page of images taken inside bbox. Takes the same paging, sorting and filter parameters as GET /images.
+// @Tags         images
+// @Produce      json
+// @Param        bbox  query  string  true  "min_lon,min_lat,max_lon,max_lat; min_lon > max_lon crosses the antimeridian"
+// @Success      200  {array}  models.Image
+// @Router       /images/within [get]
+func (ic *ImageController) WithinImages(c *gin.Context) {
+	bounds, err := parseBBox(c.Query("bbox"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	list, err := parseImageList(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	list.Query.Filter.Bounds = &bounds
+
+	ic.searchImages(c, list)
+}
+
+// parseBBox reads a "min_lon,min_lat,max_lon,max_lat" bounding box, the
+// order GeoJSON uses.
+func parseBBox(value string) (models.GeoBounds, error) {
+	var bounds models.GeoBounds
+
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return bounds, fmt.Errorf("bbox must be min_lon,min_lat,max_lon,max_lat")
+	}
+
+	var numbers [4]float64
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return bounds, fmt.Errorf("bbox must be min_lon,min_lat,max_lon,max_lat")
+		}
+		numbers[i] = n
+	}
+
+	bounds = models.GeoBounds{MinLon: numbers[0], MinLat: numbers[1], MaxLon: numbers[2], MaxLat: numbers[3]}
+	if !models.ValidCoordinates(bounds.MinLat, bounds.MinLon) || !models.ValidCoordinates(bounds.MaxLat, bounds.MaxLon) {
+		return bounds, fmt.Errorf("bbox latitudes must be within ±90 and longitudes within ±180")
+	}
+	if bounds.MinLat > bounds.MaxLat {
+		return bounds, fmt.Errorf("bbox min_lat must not be greater than max_lat")
+	}
+	return bounds, nil
+}
+
+// parseImageCoordinates turns the imagelat and imagelon strings into decimal
+// degrees. Both must be given, or neither.
+func parseImageCoordinates(lat string, lon string) (*float64, *float64, error) {
+	if lat == "" && lon == "" {
+		return nil, nil, nil
+	}
+	if lat == "" || lon == "" {
+		return nil, nil, fmt.Errorf("imagelat and imagelon must be given together")
+	}
+
+	latitude, err := models.ParseCoordinate(lat)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	longitude, err := models.ParseCoordinate(lon)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !models.ValidCoordinates(latitude, longitude) {
+		return nil, nil, fmt.Errorf("imagelat must be within ±90 and imagelon within ±180")
+	}
+	return &latitude, &longitude, nil
+}
+
+// gpsCoordinates combines the EXIF GPS position and its hemisphere
+// references into signed decimal degrees.
+func gpsCoordinates(lat string, latRef string, lon string, lonRef string) (float64, float64, bool) {
+	if lat == "" || lon == "" {
+		return 0, 0, false
+	}
+
+	latitude, err := models.ParseCoordinate(lat)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	longitude, err := models.ParseCoordinate(lon)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	if latitude > 0 && strings.HasPrefix(strings.ToUpper(latRef), "S") {
+		latitude = -latitude
+	}
+	if longitude > 0 && strings.HasPrefix(strings.ToUpper(lonRef), "W") {
+		longitude = -longitude
+	}
+
+	return latitude, longitude, models.ValidCoordinates(latitude, longitude)
+}
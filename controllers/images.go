@@ -1,34 +1,57 @@
 package controllers
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"imageApi/imaging"
 	"imageApi/models"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/barasher/go-exiftool"
 	"github.com/gin-gonic/gin"
 )
 
 type CreateImageInput struct {
-	ImageFileName    string  `json:"imagefilename" binding:"required"`
-	ImageDateTime    string  `json:"imagedatetime" binding:"required"`
-	ImageDirLocation string  `json:"imagedirlocation" binding:"required"`
-	ImageYear        int     `json:"imageyear"`
-	ImageMonth       int     `json:"imagemonth"`
-	ImageDay         int     `json:"imageday"`
-	ImageWidth       int     `json:"imagewidth"`
-	ImageHeight      int     `json:"imageheight"`
-	ImageLat         string  `json:"imagelat"`
-	ImageLon         string  `json:"imagelon"`
-	ImageSize        string  `json:"imagesize"`
-	ImageType        string  `json:"imagetype"`
-	ImageMegaPixels  float64 `json:"imagemegapixels"`
-	ImageFileSize    string  `json:"imagefilesize"`
+	ImageFileName       string          `json:"imagefilename" binding:"required"`
+	ImageDateTime       string          `json:"imagedatetime" binding:"required"`
+	ImageDirLocation    string          `json:"imagedirlocation" binding:"required"`
+	ImageYear           int             `json:"imageyear"`
+	ImageMonth          int             `json:"imagemonth"`
+	ImageDay            int             `json:"imageday"`
+	ImageWidth          int             `json:"imagewidth"`
+	ImageHeight         int             `json:"imageheight"`
+	ImageLat            string          `json:"imagelat"`
+	ImageLon            string          `json:"imagelon"`
+	ImageSize           string          `json:"imagesize"`
+	ImageType           string          `json:"imagetype"`
+	ImageMegaPixels     float64         `json:"imagemegapixels"`
+	ImageFileSize       string          `json:"imagefilesize"`
+	ImageVisibility     string          `json:"imagevisibility"`
+	ImageHash           string          `json:"-"`
+	ImagePerceptualHash string          `json:"-"`
+	ImageCameraMake     string          `json:"-"`
+	ImageCameraModel    string          `json:"-"`
+	ImageLensModel      string          `json:"-"`
+	ImageFocalLength    float64         `json:"-"`
+	ImageAperture       float64         `json:"-"`
+	ImageShutterSpeed   float64         `json:"-"`
+	ImageISO            int             `json:"-"`
+	ImageFlash          *bool           `json:"-"`
+	ImageWhiteBalance   string          `json:"-"`
+	ImageOrientation    int             `json:"-"`
+	ImageSoftware       string          `json:"-"`
+	ImageMetadata       models.Metadata `json:"-"`
+	ImageTakenAt        *time.Time      `json:"-"`
+	ImageTakenAtSource  string          `json:"-"`
+	ImageTimeZone       string          `json:"-"`
 }
 
 type UpdateImageInput struct {
@@ -46,37 +69,98 @@ type UpdateImageInput struct {
 	ImageType        string  `json:"imagetype"`
 	ImageMegaPixels  float64 `json:"imagemegapixels"`
 	ImageFileSize    string  `json:"imagefilesize"`
+	ImageVisibility  string  `json:"imagevisibility"`
+}
+
+// ImageController serves the /images routes from an ImageStore.
+type ImageController struct {
+	Store       models.ImageStore
+	RenderCache *imaging.DiskCache
+	similarity  similarityIndex
+
+	stopJobs   context.CancelFunc
+	jobWorkers sync.WaitGroup
+}
+
+func NewImageController(store models.ImageStore) *ImageController {
+	return &ImageController{
+		Store:       store,
+		RenderCache: imaging.NewDiskCache(renderCacheDir(), renderCacheMaxBytes())}
 }
 
-// GetImages responds with the list of all images as JSON.
+// GetImages responds with a page of images as JSON.
 // GetImages             godoc
 // @Summary      Get Images array
-// @Description  Responds with the list of all images as JSON.
+// @Description  Responds with a filtered, sorted page of images as JSON, with the total count and next/prev links. Raw metadata tags are matched with meta.Tag=value, such as meta.Artist=Jane.
 // @Tags         images
 // @Produce      json
+// @Param        limit              query  int     false  "page size (default 50, max 500)"
+// @Param        page               query  int     false  "page number for offset pagination"
+// @Param        cursor             query  string  false  "cursor from a next or prev link"
+// @Param        sort               query  string  false  "column to sort by, such as image_width"
+// @Param        order              query  string  false  "asc or desc"
+// @Param        year               query  int     false  "taken in year"
+// @Param        month              query  int     false  "taken in month"
+// @Param        day                query  int     false  "taken on day of month"
+// @Param        type               query  string  false  "file type, such as JPEG"
+// @Param        filename           query  string  false  "file name"
+// @Param        width_min          query  int     false  "minimum width"
+// @Param        width_max          query  int     false  "maximum width"
+// @Param        height_min         query  int     false  "minimum height"
+// @Param        height_max         query  int     false  "maximum height"
+// @Param        megapixels_min     query  number  false  "minimum megapixels"
+// @Param        megapixels_max     query  number  false  "maximum megapixels"
+// @Param        taken_after        query  string  false  "taken at or after this time, UTC unless it has an offset"
+// @Param        taken_before       query  string  false  "taken before this time, UTC unless it has an offset"
+// @Param        owner              query  string  false  "me for the caller's own images"
+// @Param        visibility         query  string  false  "private, shared or public"
+// @Param        hash               query  string  false  "SHA-256 of the file contents"
+// @Param        camera_make        query  string  false  "camera make, such as Canon"
+// @Param        camera_model       query  string  false  "camera model, such as Canon EOS R5"
+// @Param        lens_model         query  string  false  "lens model"
+// @Param        focal_length       query  number  false  "focal length in mm"
+// @Param        focal_length_min   query  number  false  "minimum focal length in mm"
+// @Param        focal_length_max   query  number  false  "maximum focal length in mm"
+// @Param        aperture           query  number  false  "f-number, such as 1.8"
+// @Param        aperture_min       query  number  false  "minimum f-number"
+// @Param        aperture_max       query  number  false  "maximum f-number"
+// @Param        shutter_speed_min  query  string  false  "shortest exposure in seconds, such as 1/250"
+// @Param        shutter_speed_max  query  string  false  "longest exposure in seconds, such as 0.5"
+// @Param        iso                query  int     false  "ISO speed"
+// @Param        iso_min            query  int     false  "minimum ISO speed"
+// @Param        iso_max            query  int     false  "maximum ISO speed"
+// @Param        flash              query  bool    false  "true when the flash fired"
+// @Param        white_balance      query  string  false  "Auto or Manual"
+// @Param        orientation        query  int     false  "EXIF orientation from 1 to 8"
+// @Param        software           query  string  false  "software that wrote the file"
 // @Success      200  {array}  models.Image
 // @Router       /images [get]
-func FindImages(c *gin.Context) {
-	var image []models.Image
-
-	models.DB.Find(&image).Find(&image)
+func (ic *ImageController) FindImages(c *gin.Context) {
+	list, err := parseImageList(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"data": image})
+	ic.searchImages(c, list)
 }
 
 // Create new image
 // PostBook             godoc
 // @Summary      Store a new image
-// @Description  Takes a image JSON and store in DB. Return saved JSON.
+// @Description  Takes a image JSON and queues it for processing. Return the queued job JSON. The capture time is read from the file's EXIF and GPS tags, then imagedatetime, then a date in the file name.
 // @Tags         images
 // @Produce      json
-// @Param        image body  models.Image  true  "Image Directory"
-// @Success      200   {object}  models.Image
+// @Param        image         body   models.Image  true   "Image Directory"
+// @Param        on_duplicate  query  string        false  "reject (default), link or force when the same file is already indexed"
+// @Success      202   {object}  JobResponse
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
 // @Router       /images [post]
 //
-// Add the imagename to the function call coming from the process images function
-// that has not been created yet
-func CreateImage(c *gin.Context) {
+// The image is processed by a background job; poll /jobs/{job_id} for the
+// stored image.
+func (ic *ImageController) CreateImage(c *gin.Context) {
 	// Validate input
 	var input CreateImageInput
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -84,32 +168,48 @@ func CreateImage(c *gin.Context) {
 		return
 	}
 
-	imageData, err := processImage(input)
+	if _, _, err := parseImageCoordinates(input.ImageLat, input.ImageLon); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
+	if input.ImageVisibility != "" && !models.ValidVisibility(input.ImageVisibility) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "imagevisibility must be private, shared or public"})
+		return
+	}
+
+	onDuplicate, err := parseOnDuplicate(c)
 	if err != nil {
-		log.Fatal(err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	// Create image
-	image := models.Image{
-		ImageFileName:    imageData.ImageFileName,
-		ImageDirLocation: imageData.ImageDirLocation,
-		ImageDateTime:    imageData.ImageDateTime,
-		ImageYear:        imageData.ImageYear,
-		ImageMonth:       imageData.ImageMonth,
-		ImageDay:         imageData.ImageDay,
-		ImageWidth:       imageData.ImageWidth,
-		ImageHeight:      imageData.ImageHeight,
-		ImageLat:         input.ImageLat,
-		ImageLon:         input.ImageLon,
-		ImageSize:        imageData.ImageSize,
-		ImageType:        imageData.ImageType,
-		ImageMegaPixels:  imageData.ImageMegaPixels,
-		ImageFileSize:    imageData.ImageFileSize}
+	input.ImageDirLocation, err = resolveLibraryPath(input.ImageDirLocation)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
 
-	models.DB.Create(&image)
+	// Check the file up front so obvious mistakes are reported right away
+	// instead of through a failed job.
+	file, err := openImageFile(input.ImageDirLocation)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	file.Close()
 
-	c.JSON(http.StatusOK, gin.H{"data": image})
+	job, err := EnqueueJob(jobCreateImage, currentUserID(c), createImagePayload{
+		CreateImageInput: input,
+		OwnerID:          currentUserID(c),
+		OnDuplicate:      onDuplicate})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("/jobs/%d", job.JobID))
+	c.JSON(http.StatusAccepted, gin.H{"data": newJobResponse(job)})
 }
 
 // Delete an image
@@ -120,16 +220,22 @@ func CreateImage(c *gin.Context) {
 // @Produce      json
 // @Param        image_id  path      string  true  "delete image by image_id"
 // @Success      200  {object}  models.Image
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
 // @Router       /images/{image_id} [delete]
-func DeleteImage(c *gin.Context) {
+func (ic *ImageController) DeleteImage(c *gin.Context) {
 	// Get model if exist
-	var image models.Image
-	if err := models.DB.Where("image_id = ?", c.Param("image_id")).First(&image).Error; err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Record not found!"})
+	image, ok := ic.findEditableImage(c)
+	if !ok {
+		return
+	}
+
+	if err := ic.Store.Delete(image.ImageID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	models.DB.Where("image_id = ?", c.Param("image_id")).Delete(&image)
+	removeThumbnails(image.ImageID)
 
 	c.JSON(http.StatusOK, gin.H{"data": true})
 }
@@ -143,32 +249,54 @@ func DeleteImage(c *gin.Context) {
 // @Param        image_id  path      string  true  "search image by ImageID"
 // @Success      200  {object}  models.Image
 // @Router       /images/{image_id} [get]
-func FindImage(c *gin.Context) { // Get model if exist
-	var image models.Image
-
-	if err := models.DB.Where("image_id = ?", c.Param("image_id")).First(&image).Find(&image).Error; err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Record not found!"})
+func (ic *ImageController) FindImage(c *gin.Context) { // Get model if exist
+	image, ok := ic.findImage(c)
+	if !ok {
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"data": image})
 }
 
+// Get image metadata
+// FindImageMetadata                godoc
+// @Summary      Get every metadata tag of an image
+// @Description  Returns every tag read from the image file, named as exiftool names them.
+// @Tags         images
+// @Produce      json
+// @Param        image_id  path      int  true  "image ID"
+// @Success      200  {object}  models.Metadata
+// @Router       /images/{image_id}/metadata [get]
+func (ic *ImageController) FindImageMetadata(c *gin.Context) {
+	image, ok := ic.findImage(c)
+	if !ok {
+		return
+	}
+
+	if image.ImageMetadata == nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Image has no metadata, reindex it first"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": image.ImageMetadata})
+}
+
 // Update an image
 // UpdateImage                godoc
 // @Summary      Update single image by image_id
-// @Description  Updates the image whose ID value matches the image_id.
+// @Description  Updates the image whose ID value matches the image_id. A new imagedirlocation must be an image file inside the image library.
 // @Tags         images
 // @Produce      json
 // @Param        image_id  path      int  true  "update image by image_id"
 // @Param        image  body      models.Image  true  "Image JSON"
 // @Success      200  {object}  models.Image
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
 // @Router       /images/{image_id} [patch]
-func UpdateImage(c *gin.Context) {
+func (ic *ImageController) UpdateImage(c *gin.Context) {
 	// Get model if exist
-	var image models.Image
-	if err := models.DB.Where("image_id = ?", c.Param("image_id")).First(&image).Error; err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Record not found!"})
+	image, ok := ic.findEditableImage(c)
+	if !ok {
 		return
 	}
 
@@ -179,6 +307,24 @@ func UpdateImage(c *gin.Context) {
 		return
 	}
 
+	// A new location is checked like one given to CreateImage, and the
+	// resolved path is stored.
+	if input.ImageDirLocation != "" {
+		location, err := resolveLibraryPath(input.ImageDirLocation)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": errorCode(err)})
+			return
+		}
+
+		file, err := openImageFile(location)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		file.Close()
+		input.ImageDirLocation = location
+	}
+
 	UpdateImageInput := models.Image{
 		ImageFileName:    input.ImageFileName,
 		ImageDirLocation: input.ImageDirLocation,
@@ -193,103 +339,321 @@ func UpdateImage(c *gin.Context) {
 		ImageSize:        input.ImageSize,
 		ImageType:        input.ImageType,
 		ImageMegaPixels:  input.ImageMegaPixels,
-		ImageFileSize:    input.ImageFileSize}
+		ImageFileSize:    input.ImageFileSize,
+		ImageVisibility:  input.ImageVisibility}
+
+	if input.ImageVisibility != "" && !models.ValidVisibility(input.ImageVisibility) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "imagevisibility must be private, shared or public"})
+		return
+	}
 
-	models.DB.Model(&image).Where("image_id = ?", c.Param("image_id")).Updates(&UpdateImageInput)
+	// A new date time replaces the capture time, and the date parts follow
+	// it.
+	if input.ImageDateTime != "" {
+		captured, err := parseCaptureTime(input.ImageDateTime)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		UpdateImageInput.ImageTakenAt, UpdateImageInput.ImageTakenAtSource, UpdateImageInput.ImageTimeZone = captured.takenAt(), captured.Source, captured.TimeZone
+		UpdateImageInput.ImageDateTime = captured.dateTime()
+		UpdateImageInput.ImageYear, UpdateImageInput.ImageMonth, UpdateImageInput.ImageDay = captured.date()
+	}
 
-	c.JSON(http.StatusOK, gin.H{"data": UpdateImageInput})
-}
+	// A new latitude or longitude is combined with the stored other half
+	// so both numeric columns stay in step.
+	if input.ImageLat != "" || input.ImageLon != "" {
+		lat, lon := image.ImageLat, image.ImageLon
+		if input.ImageLat != "" {
+			lat = input.ImageLat
+		}
+		if input.ImageLon != "" {
+			lon = input.ImageLon
+		}
 
-func testImageFile(file *os.File) bool {
+		latitude, longitude, err := parseImageCoordinates(lat, lon)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		UpdateImageInput.ImageLatitude = latitude
+		UpdateImageInput.ImageLongitude = longitude
+	}
 
-	resp, err := os.Open(file.Name())
+	image, err := ic.Store.Update(image.ImageID, UpdateImageInput)
 	if err != nil {
-		log.Fatal(err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Thumbnails of a moved file are made again when next requested.
+	if input.ImageDirLocation != "" {
+		removeThumbnails(image.ImageID)
 	}
 
-	defer resp.Close()
+	c.JSON(http.StatusOK, gin.H{"data": image})
+}
+
+// findImage loads the image named by the image_id path parameter. When there
+// is none, or the caller may not see it, it writes the error response and
+// returns false.
+func (ic *ImageController) findImage(c *gin.Context) (models.Image, bool) {
+	id, err := strconv.Atoi(c.Param("image_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Record not found!"})
+		return models.Image{}, false
+	}
 
-	// Read the response body as a byte slice
-	bytes, err := io.ReadAll(resp)
+	image, err := ic.Store.Get(id)
+	if err == models.ErrImageNotFound || err == nil && !isAdmin(c) && !image.VisibleTo(currentUserID(c)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found!"})
+		return models.Image{}, false
+	}
 	if err != nil {
-		log.Fatal(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return image, false
 	}
 
-	mimeType := http.DetectContentType(bytes)
-	fmt.Println(mimeType) // image/png
+	return image, true
+}
 
-	test := strings.Split(mimeType, "/")
+// newImage builds the image row from the processed input.
+func newImage(imageData CreateImageInput) models.Image {
+	image := models.Image{
+		ImageFileName:       imageData.ImageFileName,
+		ImageDirLocation:    imageData.ImageDirLocation,
+		ImageDateTime:       imageData.ImageDateTime,
+		ImageYear:           imageData.ImageYear,
+		ImageMonth:          imageData.ImageMonth,
+		ImageDay:            imageData.ImageDay,
+		ImageWidth:          imageData.ImageWidth,
+		ImageHeight:         imageData.ImageHeight,
+		ImageLat:            imageData.ImageLat,
+		ImageLon:            imageData.ImageLon,
+		ImageSize:           imageData.ImageSize,
+		ImageType:           imageData.ImageType,
+		ImageMegaPixels:     imageData.ImageMegaPixels,
+		ImageFileSize:       imageData.ImageFileSize,
+		ImageVisibility:     imageData.ImageVisibility,
+		ImageHash:           imageData.ImageHash,
+		ImagePerceptualHash: imageData.ImagePerceptualHash,
+		ImageCameraMake:     imageData.ImageCameraMake,
+		ImageCameraModel:    imageData.ImageCameraModel,
+		ImageLensModel:      imageData.ImageLensModel,
+		ImageFocalLength:    imageData.ImageFocalLength,
+		ImageAperture:       imageData.ImageAperture,
+		ImageShutterSpeed:   imageData.ImageShutterSpeed,
+		ImageISO:            imageData.ImageISO,
+		ImageFlash:          imageData.ImageFlash,
+		ImageWhiteBalance:   imageData.ImageWhiteBalance,
+		ImageOrientation:    imageData.ImageOrientation,
+		ImageSoftware:       imageData.ImageSoftware,
+		ImageMetadata:       imageData.ImageMetadata,
+		ImageTakenAt:        imageData.ImageTakenAt,
+		ImageTakenAtSource:  imageData.ImageTakenAtSource,
+		ImageTimeZone:       imageData.ImageTimeZone}
+
+	if image.ImageVisibility == "" {
+		image.ImageVisibility = models.VisibilityPrivate
+	}
+
+	// Coordinates are checked before processing, so an error here only
+	// leaves the image without a location.
+	image.ImageLatitude, image.ImageLongitude, _ = parseImageCoordinates(imageData.ImageLat, imageData.ImageLon)
+
+	return image
+}
 
-	fmt.Println(test)
+// testImageFile sniffs the start of file and reports whether it holds an
+// image.
+func testImageFile(file *os.File) (bool, error) {
 
-	if test[0] != "image" {
-		fmt.Println("**************************NOT AN IMAGE FILE************************")
-		return false
-	} else {
-		return true
+	// DetectContentType only looks at the first 512 bytes
+	buf := make([]byte, 512)
+	n, err := file.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return false, newImageError(ErrCodeFileUnreadable, file.Name(), err)
 	}
+
+	mimeType := http.DetectContentType(buf[:n])
+
+	return strings.HasPrefix(mimeType, "image/"), nil
 }
 
 func processImage(input CreateImageInput) (CreateImageInput, error) {
 
-	fileName := strings.Split(input.ImageDirLocation, `\`)
-	input.ImageFileName = fileName[len(fileName)-1]
+	// Paths may come from Windows shares or from the local upload storage,
+	// so accept either separator when picking out the file name.
+	input.ImageFileName = input.ImageDirLocation[strings.LastIndexAny(input.ImageDirLocation, `\/`)+1:]
 
-	// Open the image file
-	file, err := os.Open(input.ImageDirLocation)
+	file, err := openImageFile(input.ImageDirLocation)
 	if err != nil {
-		return input, fmt.Errorf("error opening file %s: %w", input.ImageDirLocation, err)
+		return input, err
 	}
 	defer file.Close()
 
-	imageFile := testImageFile(file)
-	if !imageFile {
-		return input, fmt.Errorf("Not an image file for file %s", input.ImageDirLocation)
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return input, newImageError(ErrCodeFileUnreadable, input.ImageDirLocation, err)
 	}
+	input.ImageHash = hex.EncodeToString(hash.Sum(nil))
+	input.ImagePerceptualHash = perceptualHash(input.ImageDirLocation)
 
-	et, err := exiftool.NewExiftool()
+	extractor, err := LoadMetadataExtractor()
 	if err != nil {
-		return input, fmt.Errorf("error decoding EXIF data for file %s: %w", input.ImageDirLocation, err)
+		return input, newImageError(ErrCodeExifFailed, input.ImageDirLocation, err)
 	}
-	defer et.Close()
 
-	fileInfos := et.ExtractMetadata(file.Name())
-
-	for _, fileInfo := range fileInfos {
-		if fileInfo.Err != nil {
-			fmt.Errorf("Error concerning %v: %v\n", fileInfo.File, fileInfo.Err)
-			continue
-		}
+	fields, err := extractor.Extract(file.Name())
+	if errors.Is(err, imaging.ErrUnsupportedFormat) {
+		return input, newImageError(ErrCodeUnsupported, input.ImageDirLocation, err)
+	}
+	if err != nil {
+		return input, newImageError(ErrCodeExifFailed, input.ImageDirLocation, err)
+	}
 
-		for k, v := range fileInfo.Fields {
-			fmt.Printf("[%v] %v\n", k, v)
-
-			switch {
-			case k == "CreateDate":
-				input.ImageDateTime = v.(string)
-			case k == "FileType":
-				input.ImageType = v.(string)
-			case k == "ImageWidth":
-				input.ImageWidth = int(v.(float64))
-			case k == "ImageHeight":
-				input.ImageHeight = int(v.(float64))
-			case k == "ImageSize":
-				input.ImageSize = v.(string)
-			case k == "Megapixels":
-				input.ImageMegaPixels = v.(float64)
-			case k == "FileSize":
-				input.ImageFileSize = v.(string)
+	input.ImageMetadata = models.Metadata(fields)
+
+	var gpsLat, gpsLon, gpsLatRef, gpsLonRef string
+
+	for k, v := range fields {
+		switch {
+		case k == "FileType":
+			input.ImageType = fieldString(v)
+		case k == "ImageWidth":
+			input.ImageWidth = int(fieldFloat(v))
+		case k == "ImageHeight":
+			input.ImageHeight = int(fieldFloat(v))
+		case k == "ImageSize":
+			input.ImageSize = fieldString(v)
+		case k == "Megapixels":
+			input.ImageMegaPixels = fieldFloat(v)
+		case k == "FileSize":
+			input.ImageFileSize = fieldString(v)
+		case k == "Make":
+			input.ImageCameraMake = strings.TrimSpace(fieldString(v))
+		case k == "Model":
+			input.ImageCameraModel = strings.TrimSpace(fieldString(v))
+		case k == "LensModel":
+			input.ImageLensModel = strings.TrimSpace(fieldString(v))
+		case k == "FocalLength":
+			input.ImageFocalLength, _ = parseFocalLength(fieldString(v))
+		case k == "FNumber":
+			input.ImageAperture = fieldFloat(v)
+		case k == "ExposureTime":
+			input.ImageShutterSpeed, _ = parseShutterSpeed(fieldString(v))
+		case k == "ISO":
+			input.ImageISO = int(fieldFloat(v))
+		case k == "Flash":
+			if fired, ok := imaging.FlashFired(fieldString(v)); ok {
+				input.ImageFlash = &fired
 			}
-
+		case k == "WhiteBalance":
+			input.ImageWhiteBalance = fieldString(v)
+		case k == "Orientation":
+			input.ImageOrientation, _ = imaging.ParseOrientation(fieldString(v))
+		case k == "Software":
+			input.ImageSoftware = strings.TrimSpace(fieldString(v))
+		case k == "GPSLatitude":
+			gpsLat = fieldString(v)
+		case k == "GPSLongitude":
+			gpsLon = fieldString(v)
+		case k == "GPSLatitudeRef":
+			gpsLatRef = fieldString(v)
+		case k == "GPSLongitudeRef":
+			gpsLonRef = fieldString(v)
 		}
+
 	}
 
-	dateTimeSplit := strings.Split(input.ImageDateTime, " ")
-	dateSplit := strings.Split(dateTimeSplit[0], ":")
+	// The file's own GPS position wins over one given by the caller.
+	if lat, lon, ok := gpsCoordinates(gpsLat, gpsLatRef, gpsLon, gpsLonRef); ok {
+		input.ImageLat = strconv.FormatFloat(lat, 'f', 6, 64)
+		input.ImageLon = strconv.FormatFloat(lon, 'f', 6, 64)
+	}
 
-	input.ImageYear, _ = strconv.Atoi(dateSplit[0])
-	input.ImageMonth, _ = strconv.Atoi(dateSplit[1])
-	input.ImageDay, _ = strconv.Atoi(dateSplit[2])
+	info, err := file.Stat()
+	if err != nil {
+		return input, newImageError(ErrCodeFileUnreadable, input.ImageDirLocation, err)
+	}
+	captured, err := findCaptureTime(fields, input.ImageFileName, input.ImageDateTime, info.ModTime())
+	if err != nil {
+		return input, newImageError(ErrCodeInvalidDate, input.ImageDirLocation, err)
+	}
+	input.ImageTakenAt, input.ImageTakenAtSource, input.ImageTimeZone = captured.takenAt(), captured.Source, captured.TimeZone
+	input.ImageDateTime = captured.dateTime()
+	input.ImageYear, input.ImageMonth, input.ImageDay = captured.date()
 
 	return input, nil
 }
+
+// openImageFile opens the file at path and checks that it is an image.
+func openImageFile(path string) (*os.File, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, newImageError(ErrCodeFileNotFound, path, err)
+	}
+	if err != nil {
+		return nil, newImageError(ErrCodeFileUnreadable, path, err)
+	}
+
+	isImage, err := testImageFile(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !isImage {
+		file.Close()
+		return nil, newImageError(ErrCodeNotImage, path, fmt.Errorf("not an image file"))
+	}
+
+	return file, nil
+}
+
+// fieldString and fieldFloat read metadata values, which like exiftool's
+// JSON output may be either strings or numbers.
+func fieldString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+func fieldFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case string:
+		f, _ := strconv.ParseFloat(n, 64)
+		return f
+	}
+	return 0
+}
+
+// parseFocalLength reads a focal length in millimetres as exiftool prints
+// it, such as "50.0 mm".
+func parseFocalLength(value string) (float64, error) {
+	return strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "mm")), 64)
+}
+
+// parseShutterSpeed reads an exposure time in seconds, given as a fraction
+// such as "1/250" or as a number such as "0.5".
+func parseShutterSpeed(value string) (float64, error) {
+	numerator, denominator, isFraction := strings.Cut(strings.TrimSpace(value), "/")
+	if !isFraction {
+		return strconv.ParseFloat(numerator, 64)
+	}
+
+	n, err := strconv.ParseFloat(numerator, 64)
+	if err != nil {
+		return 0, err
+	}
+	d, err := strconv.ParseFloat(denominator, 64)
+	if err != nil {
+		return 0, err
+	}
+	if d == 0 {
+		return 0, fmt.Errorf("zero denominator in %q", value)
+	}
+	return n / d, nil
+}
@@ -0,0 +1,267 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"imageApi/models"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ImportInput struct {
+	ImportDir   string `json:"importdir" binding:"required"`
+	Visibility  string `json:"visibility"`
+	OnDuplicate string `json:"on_duplicate"`
+}
+
+// ImportOptions apply to the rows an import creates. OnDuplicate decides what
+// happens to a new file with the same contents as an indexed image: reject
+// fails it, link counts it without storing a row and force stores it anyway.
+// Existing rows are only refreshed when OwnerID may edit them, or for
+// admins.
+type ImportOptions struct {
+	OwnerID     uint
+	Admin       bool
+	Visibility  string
+	OnDuplicate string
+}
+
+// ImportError records why a single file could not be imported.
+type ImportError struct {
+	Path  string `json:"path"`
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+// ImportResult summarises a directory import.
+type ImportResult struct {
+	Created int           `json:"created"`
+	Updated int           `json:"updated"`
+	Skipped int           `json:"skipped"`
+	Linked  int           `json:"linked"`
+	Failed  int           `json:"failed"`
+	Errors  []ImportError `json:"errors"`
+}
+
+// Import a directory
+// ImportImages             godoc
+// @Summary      Import a directory of images
+// @Description  Queues a job that walks the directory, which must be below one of the IMAGE_LIBRARY_ROOTS, recursively and indexes every image file found. New images belong to the caller, and images already indexed are only refreshed when the caller may edit them. Returns the queued job JSON.
+// @Tags         images
+// @Produce      json
+// @Param        import body  ImportInput  true  "Import Directory"
+// @Success      202   {object}  JobResponse
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Router       /images/import [post]
+//
+// The import runs as a background job; poll /jobs/{job_id} for how it went.
+func (ic *ImageController) ImportImages(c *gin.Context) {
+	// Validate input
+	var input ImportInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.Visibility == "" {
+		input.Visibility = models.VisibilityPrivate
+	}
+	if !models.ValidVisibility(input.Visibility) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "visibility must be private, shared or public"})
+		return
+	}
+
+	if input.OnDuplicate == "" {
+		input.OnDuplicate = onDuplicateReject
+	}
+	if !validOnDuplicate(input.OnDuplicate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "on_duplicate must be reject, link or force"})
+		return
+	}
+
+	root, err := resolveLibraryPath(input.ImportDir)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	// Check the directory up front so obvious mistakes are reported right
+	// away instead of through a failed job.
+	info, err := os.Stat(root)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !info.IsDir() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "importdir must be a directory"})
+		return
+	}
+
+	job, err := EnqueueJob(jobImportImages, currentUserID(c), importPayload{
+		ImportDir: root,
+		Options: ImportOptions{
+			OwnerID:     currentUserID(c),
+			Admin:       isAdmin(c),
+			Visibility:  input.Visibility,
+			OnDuplicate: input.OnDuplicate}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Location", fmt.Sprintf("/jobs/%d", job.JobID))
+	c.JSON(http.StatusAccepted, gin.H{"data": newJobResponse(job)})
+}
+
+// ImportDirectory walks root, which must be in the image library, recursively
+// and creates or updates an image row for every image file below it. Files
+// that are not images are skipped and per-file failures are collected in the
+// result rather than stopping the walk, which only stops early with ctx's
+// error once it is done. New rows get the owner and visibility of options;
+// rows that already exist keep theirs.
+func (ic *ImageController) ImportDirectory(ctx context.Context, root string, options ImportOptions) (ImportResult, error) {
+	result := ImportResult{Errors: []ImportError{}}
+
+	root, err := resolveLibraryPath(root)
+	if err != nil {
+		return result, err
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return result, err
+	}
+	if !info.IsDir() {
+		return result, &fs.PathError{Op: "import", Path: root, Err: fs.ErrInvalid}
+	}
+
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			result.fail(path, err)
+			if d != nil && d.IsDir() && path != root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		isImage, err := isImagePath(path)
+		if err != nil {
+			result.fail(path, err)
+			return nil
+		}
+		if !isImage {
+			result.Skipped++
+			return nil
+		}
+
+		outcome, err := ic.importImage(path, options)
+		if err != nil {
+			result.fail(path, err)
+			return nil
+		}
+
+		switch outcome {
+		case importCreated:
+			result.Created++
+		case importUpdated:
+			result.Updated++
+		case importLinked:
+			result.Linked++
+		}
+		return nil
+	})
+
+	return result, err
+}
+
+func (r *ImportResult) fail(path string, err error) {
+	r.Failed++
+	r.Errors = append(r.Errors, ImportError{Path: path, Code: errorCode(err), Error: err.Error()})
+}
+
+func isImagePath(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, newImageError(ErrCodeFileUnreadable, path, err)
+	}
+	defer file.Close()
+
+	return testImageFile(file)
+}
+
+// What importImage did with a file.
+const (
+	importCreated = iota
+	importUpdated
+	importLinked
+)
+
+// importImage processes the file at path and upserts its row, keyed on the
+// file location. Only files at new locations are checked for duplicates.
+func (ic *ImageController) importImage(path string, options ImportOptions) (int, error) {
+	imageData, err := processImage(CreateImageInput{ImageDirLocation: path})
+	if err != nil {
+		return 0, err
+	}
+
+	image := newImage(imageData)
+
+	existing, _, err := ic.Store.Search(models.ImageQuery{Filter: models.ImageFilter{DirLocation: path}, Limit: 1})
+	if err != nil {
+		return 0, err
+	}
+
+	if len(existing) > 0 {
+		if !options.Admin && !existing[0].EditableBy(options.OwnerID) {
+			return 0, newImageError(ErrCodeNotOwner, path, fmt.Errorf("image %d belongs to another user", existing[0].ImageID))
+		}
+		_, err := ic.refreshImage(existing[0].ImageID, image)
+		return importUpdated, err
+	}
+
+	duplicate, err := ic.checkDuplicate(image, options.OnDuplicate, ownerAccess(options.OwnerID))
+	if err != nil {
+		return 0, err
+	}
+	if duplicate != nil {
+		return importLinked, nil
+	}
+
+	image.ImageOwnerID = options.OwnerID
+	image.ImageVisibility = options.Visibility
+	if err := ic.Store.Create(&image); err != nil {
+		return 0, err
+	}
+
+	ic.similarity.add(image)
+	generateThumbnails(image)
+	return importCreated, nil
+}
+
+// refreshImage stores freshly processed metadata for an existing image and
+// remakes its thumbnails, since the file may have changed. Every other field
+// is replaced, so metadata the file no longer has is cleared, but the owner
+// and visibility are kept.
+func (ic *ImageController) refreshImage(imageID int, image models.Image) (models.Image, error) {
+	updated, err := ic.Store.Replace(imageID, image, "ImageOwnerID", "ImageVisibility")
+	if err != nil {
+		return updated, err
+	}
+
+	ic.similarity.add(updated)
+	removeThumbnails(updated.ImageID)
+	generateThumbnails(updated)
+	return updated, nil
+}
@@ -0,0 +1,412 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"imageApi/models"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Job types handled by the workers.
+const (
+	jobCreateImage   = "create_image"
+	jobImportImages  = "import_images"
+	jobReindexImages = "reindex_images"
+)
+
+// JOB_WORKERS sets how many jobs run at once. Workers pick up new jobs as
+// soon as they are queued and otherwise poll the jobs table. A running job's
+// heartbeat is renewed every jobHeartbeatInterval, and a job whose heartbeat
+// is older than jobLeaseTimeout is taken to have lost its worker, in this or
+// another process, and is queued again.
+const (
+	defaultJobWorkers    = 4
+	jobPollInterval      = 5 * time.Second
+	jobHeartbeatInterval = 30 * time.Second
+	jobLeaseTimeout      = 2 * time.Minute
+)
+
+var jobWake = make(chan struct{}, 1)
+
+// StartJobWorkers starts the worker pool and requeues running jobs whose
+// heartbeat has expired, now and from then on. It must be called after the
+// database is connected.
+func (ic *ImageController) StartJobWorkers() {
+	workers := int(getEnvInt("JOB_WORKERS", defaultJobWorkers))
+	if workers < 1 {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ic.stopJobs = cancel
+	for i := 0; i < workers; i++ {
+		ic.jobWorkers.Add(1)
+		go ic.jobWorker(ctx)
+	}
+
+	ic.jobWorkers.Add(1)
+	go func() {
+		defer ic.jobWorkers.Done()
+		for {
+			requeueExpiredJobs()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(jobLeaseTimeout / 2):
+			}
+		}
+	}()
+}
+
+// StopJobWorkers stops the workers from claiming jobs and waits for the
+// running ones to finish, or until ctx is done. A reindex stops between
+// images and is queued again for the next start.
+func (ic *ImageController) StopJobWorkers(ctx context.Context) error {
+	if ic.stopJobs == nil {
+		return nil
+	}
+	ic.stopJobs()
+
+	done := make(chan struct{})
+	go func() {
+		ic.jobWorkers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// JobResponse is the status of a job as its owner sees it. The payload stays
+// internal.
+type JobResponse struct {
+	JobID        int
+	JobStatus    string
+	JobImageID   int
+	JobError     string
+	JobErrorCode string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func newJobResponse(job models.Job) JobResponse {
+	return JobResponse{
+		JobID:        job.JobID,
+		JobStatus:    job.JobStatus,
+		JobImageID:   job.JobImageID,
+		JobError:     job.JobError,
+		JobErrorCode: job.JobErrorCode,
+		CreatedAt:    job.CreatedAt,
+		UpdatedAt:    job.UpdatedAt}
+}
+
+// EnqueueJob stores a new queued job of the user with the JSON encoded
+// payload and wakes a worker to run it.
+func EnqueueJob(jobType string, ownerID uint, payload interface{}) (models.Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return models.Job{}, err
+	}
+
+	job := models.Job{
+		JobType:    jobType,
+		JobStatus:  models.JobQueued,
+		JobPayload: string(data),
+		JobOwnerID: ownerID}
+
+	if err := models.DB.Create(&job).Error; err != nil {
+		return job, err
+	}
+
+	select {
+	case jobWake <- struct{}{}:
+	default:
+	}
+
+	return job, nil
+}
+
+// Find a job
+// FindJob                godoc
+// @Summary      Get single job by job_id
+// @Description  Returns the status of the background job whose ID value matches the job_id. Users only see the jobs they queued; admins see every job.
+// @Tags         jobs
+// @Produce      json
+// @Param        job_id  path      string  true  "search job by job_id"
+// @Success      200  {object}  JobResponse
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Router       /jobs/{job_id} [get]
+func FindJob(c *gin.Context) {
+	var job models.Job
+
+	query := models.DB.Where("job_id = ?", c.Param("job_id"))
+	if !isAdmin(c) {
+		query = query.Where("job_owner_id = ?", currentUserID(c))
+	}
+	if err := query.First(&job).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Record not found!"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": newJobResponse(job)})
+}
+
+func (ic *ImageController) jobWorker(ctx context.Context) {
+	defer ic.jobWorkers.Done()
+
+	for ctx.Err() == nil {
+		job, ok := claimJob()
+		if !ok {
+			select {
+			case <-ctx.Done():
+			case <-jobWake:
+			case <-time.After(jobPollInterval):
+			}
+			continue
+		}
+
+		imageID, err := ic.runClaimedJob(ctx, job)
+
+		update := map[string]interface{}{
+			"job_status":     models.JobSucceeded,
+			"job_error":      "",
+			"job_error_code": "",
+			"job_image_id":   imageID}
+		if errors.Is(err, context.Canceled) {
+			update = map[string]interface{}{"job_status": models.JobQueued}
+		} else if err != nil {
+			update["job_status"] = models.JobFailed
+			update["job_error"] = err.Error()
+			update["job_error_code"] = errorCode(err)
+		}
+
+		if err := models.DB.Model(&job).Updates(update).Error; err != nil {
+			log.Printf("error finishing job %d: %v", job.JobID, err)
+		}
+	}
+}
+
+// runClaimedJob runs the job while renewing its heartbeat. A panic fails
+// the job instead of taking the server down.
+func (ic *ImageController) runClaimedJob(ctx context.Context, job models.Job) (imageID int, err error) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(jobHeartbeatInterval):
+			}
+			err := models.DB.Model(&models.Job{}).
+				Where("job_id = ? AND job_status = ?", job.JobID, models.JobRunning).
+				Update("job_heartbeat_at", time.Now()).Error
+			if err != nil {
+				log.Printf("error renewing job %d: %v", job.JobID, err)
+			}
+		}
+	}()
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("job %d panicked: %v\n%s", job.JobID, r, debug.Stack())
+			imageID, err = 0, fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+
+	return ic.runJob(ctx, job)
+}
+
+// requeueExpiredJobs queues the running jobs whose heartbeat has expired
+// again. Jobs from before heartbeats were kept have none.
+func requeueExpiredJobs() {
+	result := models.DB.Model(&models.Job{}).
+		Where("job_status = ? AND (job_heartbeat_at IS NULL OR job_heartbeat_at < ?)", models.JobRunning, time.Now().Add(-jobLeaseTimeout)).
+		Update("job_status", models.JobQueued)
+	if result.Error != nil {
+		log.Println("error requeuing interrupted jobs:", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("requeued %d interrupted jobs", result.RowsAffected)
+		select {
+		case jobWake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// claimJob marks the oldest queued job as running and returns it. The status
+// check in the update keeps two workers from claiming the same job.
+func claimJob() (models.Job, bool) {
+	for {
+		var job models.Job
+		if err := models.DB.Where("job_status = ?", models.JobQueued).Order("job_id").First(&job).Error; err != nil {
+			return job, false
+		}
+
+		result := models.DB.Model(&models.Job{}).
+			Where("job_id = ? AND job_status = ?", job.JobID, models.JobQueued).
+			Updates(map[string]interface{}{"job_status": models.JobRunning, "job_heartbeat_at": time.Now()})
+		if result.Error != nil {
+			log.Printf("error claiming job %d: %v", job.JobID, result.Error)
+			return job, false
+		}
+
+		if result.RowsAffected == 1 {
+			job.JobStatus = models.JobRunning
+			return job, true
+		}
+	}
+}
+
+// createImagePayload is the payload of a create_image job. The owner is the
+// user who posted the image, not something the client may set.
+type createImagePayload struct {
+	CreateImageInput
+	OwnerID     uint   `json:"ownerid"`
+	OnDuplicate string `json:"onduplicate"`
+}
+
+// importPayload is the payload of an import_images job. ImportDir is
+// already resolved within the library.
+type importPayload struct {
+	ImportDir string        `json:"importdir"`
+	Options   ImportOptions `json:"options"`
+}
+
+// reindexPayload is the payload of a reindex_images job. No IDs means every
+// image.
+type reindexPayload struct {
+	ImageIDs []int `json:"imageids"`
+}
+
+// runJob runs a claimed job and returns the ID of the image it produced.
+// Jobs that take long give up with ctx's error once it is done.
+func (ic *ImageController) runJob(ctx context.Context, job models.Job) (int, error) {
+	switch job.JobType {
+	case jobCreateImage:
+		var payload createImagePayload
+		if err := json.Unmarshal([]byte(job.JobPayload), &payload); err != nil {
+			return 0, err
+		}
+
+		imageData, err := processImage(payload.CreateImageInput)
+		if err != nil {
+			return 0, err
+		}
+
+		image := newImage(imageData)
+		image.ImageOwnerID = payload.OwnerID
+
+		// A linked duplicate finishes the job with the existing image.
+		existing, err := ic.checkDuplicate(image, payload.OnDuplicate, ownerAccess(payload.OwnerID))
+		if err != nil {
+			return 0, err
+		}
+		if existing != nil {
+			return existing.ImageID, nil
+		}
+
+		if err := ic.Store.Create(&image); err != nil {
+			return 0, err
+		}
+
+		ic.similarity.add(image)
+		generateThumbnails(image)
+		return image.ImageID, nil
+	case jobImportImages:
+		var payload importPayload
+		if err := json.Unmarshal([]byte(job.JobPayload), &payload); err != nil {
+			return 0, err
+		}
+
+		result, err := ic.ImportDirectory(ctx, payload.ImportDir, payload.Options)
+		if err != nil {
+			return 0, err
+		}
+		log.Printf("job %d imported %s: %d created, %d updated, %d linked, %d skipped, %d failed",
+			job.JobID, payload.ImportDir, result.Created, result.Updated, result.Linked, result.Skipped, result.Failed)
+
+		if result.Failed > 0 {
+			first := result.Errors[0]
+			return 0, newImageError(first.Code, payload.ImportDir, fmt.Errorf("%d files could not be imported, first error: %s", result.Failed, first.Error))
+		}
+		return 0, nil
+	case jobReindexImages:
+		var payload reindexPayload
+		if err := json.Unmarshal([]byte(job.JobPayload), &payload); err != nil {
+			return 0, err
+		}
+		return 0, ic.reindexImages(ctx, payload.ImageIDs)
+	default:
+		return 0, fmt.Errorf("unknown job type %q", job.JobType)
+	}
+}
+
+// reindexImages processes the files of the images again and stores the
+// fresh metadata. Every image is tried; the error reports how many failed.
+func (ic *ImageController) reindexImages(ctx context.Context, imageIDs []int) error {
+	var images []models.Image
+	if len(imageIDs) == 0 {
+		var err error
+		if images, err = ic.Store.List(); err != nil {
+			return err
+		}
+	} else {
+		for _, id := range imageIDs {
+			image, err := ic.Store.Get(id)
+			if err == models.ErrImageNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			images = append(images, image)
+		}
+	}
+
+	var failed int
+	var firstErr error
+	for _, image := range images {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// A date time given by a caller is kept when the file has nothing
+		// better.
+		input := CreateImageInput{ImageDirLocation: image.ImageDirLocation}
+		if image.ImageTakenAtSource == models.TakenAtImageDateTime {
+			input.ImageDateTime = image.ImageDateTime
+		}
+
+		imageData, err := processImage(input)
+		if err == nil {
+			_, err = ic.refreshImage(image.ImageID, newImage(imageData))
+		}
+		if err != nil {
+			log.Printf("error reindexing image %d: %v", image.ImageID, err)
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d images could not be reindexed, first error: %w", failed, len(images), firstErr)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"context"
+	"imageApi/models"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func getJob(t *testing.T, jobID int) models.Job {
+	t.Helper()
+
+	var job models.Job
+	if err := models.DB.Where("job_id = ?", jobID).First(&job).Error; err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestClaimJobIsExclusive(t *testing.T) {
+	useTestDB(t)
+
+	const jobs = 100
+	for i := 0; i < jobs; i++ {
+		if _, err := EnqueueJob(jobReindexImages, 1, reindexPayload{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mu sync.Mutex
+	claims := map[int]int{}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, ok := claimJob()
+				if !ok {
+					return
+				}
+				mu.Lock()
+				claims[job.JobID]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claims) != jobs {
+		t.Errorf("%d jobs claimed, want %d", len(claims), jobs)
+	}
+	for jobID, count := range claims {
+		if count != 1 {
+			t.Errorf("job %d claimed %d times", jobID, count)
+		}
+		if job := getJob(t, jobID); job.JobStatus != models.JobRunning || job.JobHeartbeatAt == nil {
+			t.Errorf("claimed job %d is %s with heartbeat %v", jobID, job.JobStatus, job.JobHeartbeatAt)
+		}
+	}
+}
+
+func TestRequeueExpiredJobs(t *testing.T) {
+	useTestDB(t)
+
+	stale := time.Now().Add(-jobLeaseTimeout - time.Minute)
+	fresh := time.Now().Add(-jobLeaseTimeout / 2)
+	tests := []struct {
+		name      string
+		status    string
+		heartbeat *time.Time
+		want      string
+	}{
+		{"stale heartbeat", models.JobRunning, &stale, models.JobQueued},
+		{"no heartbeat", models.JobRunning, nil, models.JobQueued},
+		{"fresh heartbeat", models.JobRunning, &fresh, models.JobRunning},
+		{"finished job", models.JobSucceeded, &stale, models.JobSucceeded},
+		{"failed job", models.JobFailed, nil, models.JobFailed},
+	}
+
+	ids := make([]int, len(tests))
+	for i, test := range tests {
+		job := models.Job{JobType: jobReindexImages, JobStatus: test.status, JobHeartbeatAt: test.heartbeat}
+		if err := models.DB.Create(&job).Error; err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = job.JobID
+	}
+
+	requeueExpiredJobs()
+
+	for i, test := range tests {
+		if status := getJob(t, ids[i]).JobStatus; status != test.want {
+			t.Errorf("%s: status %s, want %s", test.name, status, test.want)
+		}
+	}
+}
+
+// A job that panics is failed by the worker, which goes on to run the next
+// job.
+func TestPanickingJobFails(t *testing.T) {
+	useTestDB(t)
+	t.Setenv("JOB_WORKERS", "1")
+
+	// Without a store every reindex panics.
+	ic := &ImageController{}
+	panicking, err := EnqueueJob(jobReindexImages, 1, reindexPayload{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown, err := EnqueueJob("unknown", 1, struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ic.StartJobWorkers()
+	deadline := time.Now().Add(10 * time.Second)
+	for getJob(t, unknown.JobID).JobStatus != models.JobFailed && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := ic.StopJobWorkers(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	job := getJob(t, panicking.JobID)
+	if job.JobStatus != models.JobFailed || !strings.Contains(job.JobError, "job panicked") {
+		t.Errorf("panicking job is %s with error %q", job.JobStatus, job.JobError)
+	}
+	if job := getJob(t, unknown.JobID); job.JobStatus != models.JobFailed {
+		t.Errorf("next job is %s, want %s", job.JobStatus, models.JobFailed)
+	}
+}
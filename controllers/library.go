@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Images are only indexed and served from below the directories listed in
+// IMAGE_LIBRARY_ROOTS, separated by commas, and the upload storage root.
+// Paths are made absolute and their symlinks resolved before they are
+// checked, so neither ".." nor a link leads out of a root.
+
+var errOutsideLibrary = errors.New("path is outside the image library")
+
+// libraryRoots returns the resolved library and storage roots.
+func libraryRoots() []string {
+	var roots []string
+	for _, root := range append(strings.Split(os.Getenv("IMAGE_LIBRARY_ROOTS"), ","), storageRoot()) {
+		root = strings.TrimSpace(root)
+		if root == "" {
+			continue
+		}
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+			abs = resolved
+		}
+		roots = append(roots, abs)
+	}
+	return roots
+}
+
+// inLibrary reports whether the absolute, clean path is a library root or
+// lies below one.
+func inLibrary(path string) bool {
+	for _, root := range libraryRoots() {
+		rel, err := filepath.Rel(root, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveLibraryPath returns path made absolute with its symlinks resolved,
+// or an ErrCodePathNotAllowed error when that is outside the library. A
+// path that does not exist is checked as written, and is only reported as
+// missing later when it is inside the library, so callers cannot find out
+// which files exist elsewhere.
+func resolveLibraryPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", newImageError(ErrCodePathNotAllowed, path, errOutsideLibrary)
+	}
+
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		// Resolve the directory, so a link to outside the library is
+		// caught even when the file in it is missing.
+		resolved = abs
+		if dir, err := filepath.EvalSymlinks(filepath.Dir(abs)); err == nil {
+			resolved = filepath.Join(dir, filepath.Base(abs))
+		}
+	}
+	if !inLibrary(resolved) {
+		return "", newImageError(ErrCodePathNotAllowed, path, errOutsideLibrary)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"fmt"
+	"imageApi/imaging"
+	"sync"
+	"time"
+)
+
+// METADATA_EXTRACTOR picks how image metadata is read: native, the default,
+// reads JPEG, PNG, TIFF and WebP files in pure Go, and exiftool runs the
+// exiftool program, which must be installed but reads more formats. The
+// exiftool backend keeps EXIFTOOL_POOL_SIZE processes running, checks the
+// idle ones every EXIFTOOL_HEALTH_CHECK_SECONDS and replaces one that takes
+// more than EXIFTOOL_TIMEOUT_SECONDS to read a file.
+const (
+	metadataNative   = "native"
+	metadataExiftool = "exiftool"
+
+	defaultExiftoolPoolSize    = 4
+	defaultExiftoolHealthCheck = 60
+	defaultExiftoolTimeout     = 30
+)
+
+var (
+	metadataOnce      sync.Once
+	metadataExtractor imaging.MetadataExtractor
+	metadataErr       error
+)
+
+// LoadMetadataExtractor sets up the configured metadata extractor. It is
+// called on start so a bad setting is reported right away; later calls
+// return the same extractor.
+func LoadMetadataExtractor() (imaging.MetadataExtractor, error) {
+	metadataOnce.Do(func() {
+		switch backend := getEnv("METADATA_EXTRACTOR", metadataNative); backend {
+		case metadataNative:
+			metadataExtractor = imaging.NativeExtractor{}
+		case metadataExiftool:
+			pool, err := imaging.NewExiftoolPool(
+				int(getEnvInt("EXIFTOOL_POOL_SIZE", defaultExiftoolPoolSize)),
+				time.Duration(getEnvInt("EXIFTOOL_HEALTH_CHECK_SECONDS", defaultExiftoolHealthCheck))*time.Second,
+				time.Duration(getEnvInt("EXIFTOOL_TIMEOUT_SECONDS", defaultExiftoolTimeout))*time.Second)
+			if err != nil {
+				metadataErr = err
+				return
+			}
+			metadataExtractor = pool
+		default:
+			metadataErr = fmt.Errorf("METADATA_EXTRACTOR must be native or exiftool, not %q", backend)
+		}
+	})
+	return metadataExtractor, metadataErr
+}
+
+// CloseMetadataExtractor releases the metadata extractor, stopping any
+// exiftool processes. It must only be called once the server has stopped
+// taking requests and the job workers have stopped.
+func CloseMetadataExtractor() error {
+	if metadataExtractor == nil {
+		return nil
+	}
+	return metadataExtractor.Close()
+}
@@ -0,0 +1,339 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"imageApi/imaging"
+	"imageApi/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GET /images returns at most maxPageLimit images per page.
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// exifDateTimeLayout is the date time format exiftool uses for CreateDate,
+// which is how ImageDateTime is stored.
+const exifDateTimeLayout = "2006:01:02 15:04:05"
+
+// imageList is a parsed GET /images request. Page is zero for cursor
+// pagination.
+type imageList struct {
+	Query models.ImageQuery
+	Page  int
+}
+
+// parseImageList reads the pagination, sort and filter query parameters.
+func parseImageList(c *gin.Context) (imageList, error) {
+	var list imageList
+	var err error
+	query := &list.Query
+
+	query.Limit = defaultPageLimit
+	if value := c.Query("limit"); value != "" {
+		query.Limit, err = strconv.Atoi(value)
+		if err != nil || query.Limit < 1 || query.Limit > maxPageLimit {
+			return list, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+	}
+
+	if sortColumn := c.Query("sort"); sortColumn != "" {
+		if !models.IsSortColumn(sortColumn) {
+			return list, fmt.Errorf("cannot sort by %q, use one of %s", sortColumn, strings.Join(models.SortColumns(), ", "))
+		}
+		query.Sort = sortColumn
+	}
+
+	switch c.DefaultQuery("order", "asc") {
+	case "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return list, fmt.Errorf("order must be asc or desc")
+	}
+
+	if value := c.Query("page"); value != "" {
+		if c.Query("cursor") != "" {
+			return list, fmt.Errorf("use either page or cursor, not both")
+		}
+		list.Page, err = strconv.Atoi(value)
+		if err != nil || list.Page < 1 {
+			return list, fmt.Errorf("page must be a positive number")
+		}
+		query.Offset = (list.Page - 1) * query.Limit
+	}
+
+	if value := c.Query("cursor"); value != "" {
+		query.Cursor, err = decodeCursor(value)
+		if err != nil {
+			return list, fmt.Errorf("invalid cursor")
+		}
+	}
+
+	query.Filter, err = parseImageFilter(c)
+	return list, err
+}
+
+// parseImageFilter reads the equality and range filters.
+func parseImageFilter(c *gin.Context) (models.ImageFilter, error) {
+	filter := models.ImageFilter{
+		FileName: c.Query("filename"),
+		Type:     c.Query("type"),
+		Hash:     c.Query("hash"),
+		Access:   imageAccess(c),
+
+		CameraMake:   c.Query("camera_make"),
+		CameraModel:  c.Query("camera_model"),
+		LensModel:    c.Query("lens_model"),
+		WhiteBalance: c.Query("white_balance"),
+		Software:     c.Query("software")}
+
+	if value := c.Query("owner"); value != "" {
+		if value != "me" {
+			return filter, fmt.Errorf("owner must be me")
+		}
+		filter.OwnerID = currentUserID(c)
+		if filter.OwnerID == 0 {
+			return filter, fmt.Errorf("owner=me needs a signed in user")
+		}
+	}
+
+	if value := c.Query("visibility"); value != "" {
+		if !models.ValidVisibility(value) {
+			return filter, fmt.Errorf("visibility must be private, shared or public")
+		}
+		filter.Visibility = value
+	}
+
+	ints := map[string]*int{
+		"year":       &filter.Year,
+		"month":      &filter.Month,
+		"day":        &filter.Day,
+		"width_min":  &filter.WidthMin,
+		"width_max":  &filter.WidthMax,
+		"height_min": &filter.HeightMin,
+		"height_max": &filter.HeightMax,
+		"iso_min":    &filter.ISOMin,
+		"iso_max":    &filter.ISOMax,
+	}
+	for name, target := range ints {
+		if value := c.Query(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be a whole number", name)
+			}
+			*target = n
+		}
+	}
+
+	floats := map[string]*float64{
+		"megapixels_min":   &filter.MegaPixelsMin,
+		"megapixels_max":   &filter.MegaPixelsMax,
+		"focal_length_min": &filter.FocalLengthMin,
+		"focal_length_max": &filter.FocalLengthMax,
+		"aperture_min":     &filter.ApertureMin,
+		"aperture_max":     &filter.ApertureMax,
+	}
+	for name, target := range floats {
+		if value := c.Query(name); value != "" {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be a number", name)
+			}
+			*target = f
+		}
+	}
+
+	// A single value matches exactly.
+	if value := c.Query("iso"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return filter, fmt.Errorf("iso must be a whole number")
+		}
+		filter.ISOMin, filter.ISOMax = n, n
+	}
+	exact := map[string][2]*float64{
+		"focal_length": {&filter.FocalLengthMin, &filter.FocalLengthMax},
+		"aperture":     {&filter.ApertureMin, &filter.ApertureMax},
+	}
+	for name, targets := range exact {
+		if value := c.Query(name); value != "" {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be a number", name)
+			}
+			*targets[0], *targets[1] = f, f
+		}
+	}
+
+	shutterSpeeds := map[string]*float64{
+		"shutter_speed_min": &filter.ShutterSpeedMin,
+		"shutter_speed_max": &filter.ShutterSpeedMax,
+	}
+	for name, target := range shutterSpeeds {
+		if value := c.Query(name); value != "" {
+			f, err := parseShutterSpeed(value)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be seconds such as 1/250 or 0.5", name)
+			}
+			*target = f
+		}
+	}
+
+	if value := c.Query("flash"); value != "" {
+		fired, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("flash must be true or false")
+		}
+		filter.Flash = &fired
+	}
+
+	if value := c.Query("orientation"); value != "" {
+		orientation, ok := imaging.ParseOrientation(value)
+		if !ok {
+			return filter, fmt.Errorf("orientation must be between 1 and 8")
+		}
+		filter.Orientation = orientation
+	}
+
+	// Raw metadata tags are matched as meta.Tag=value.
+	for name, values := range c.Request.URL.Query() {
+		key := strings.TrimPrefix(name, "meta.")
+		if key == name {
+			continue
+		}
+		if !models.ValidMetadataKey(key) {
+			return filter, fmt.Errorf("%s is not a metadata tag name", name)
+		}
+		if filter.Metadata == nil {
+			filter.Metadata = map[string]string{}
+		}
+		filter.Metadata[key] = values[0]
+	}
+
+	times := map[string]*time.Time{
+		"taken_after":  &filter.TakenAfter,
+		"taken_before": &filter.TakenBefore,
+	}
+	for name, target := range times {
+		if value := c.Query(name); value != "" {
+			t, err := parseTime(value)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be a date such as 2023-05-14 or 2023-05-14T10:00:00Z", name)
+			}
+			*target = t
+		}
+	}
+
+	return filter, nil
+}
+
+// parseTime accepts RFC 3339 times, plain dates and EXIF date times.
+func parseTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02", exifDateTimeLayout} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse time %q", value)
+}
+
+func encodeCursor(cursor models.ImageCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (*models.ImageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor models.ImageCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// pageLink returns the current request URL with the given query parameters
+// replaced. Empty values remove the parameter.
+func pageLink(c *gin.Context, params map[string]string) *string {
+	u := *c.Request.URL
+	values := u.Query()
+	for name, value := range params {
+		if value == "" {
+			values.Del(name)
+		} else {
+			values.Set(name, value)
+		}
+	}
+	u.RawQuery = values.Encode()
+
+	link := u.RequestURI()
+	return &link
+}
+
+// searchImages runs the list request and writes the page with its total and
+// next/prev links.
+func (ic *ImageController) searchImages(c *gin.Context, list imageList) {
+	query := list.Query
+
+	// Ask for one extra image in cursor mode to learn if there is more.
+	if list.Page == 0 {
+		query.Limit++
+	}
+
+	images, total, err := ic.Store.Search(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var next, prev *string
+	response := gin.H{"total": total, "limit": list.Query.Limit}
+
+	if list.Page > 0 {
+		if list.Page*list.Query.Limit < total {
+			next = pageLink(c, map[string]string{"page": strconv.Itoa(list.Page + 1)})
+		}
+		if list.Page > 1 {
+			prev = pageLink(c, map[string]string{"page": strconv.Itoa(list.Page - 1)})
+		}
+		response["page"] = list.Page
+	} else {
+		before := query.Cursor != nil && query.Cursor.Before
+		more := len(images) > list.Query.Limit
+		if more {
+			if before {
+				images = images[1:]
+			} else {
+				images = images[:list.Query.Limit]
+			}
+		}
+
+		if len(images) > 0 {
+			if more || before {
+				cursor := list.Query.CursorFor(images[len(images)-1], false)
+				next = pageLink(c, map[string]string{"cursor": encodeCursor(cursor)})
+			}
+			if (before && more) || (!before && query.Cursor != nil) {
+				cursor := list.Query.CursorFor(images[0], true)
+				prev = pageLink(c, map[string]string{"cursor": encodeCursor(cursor)})
+			}
+		}
+	}
+
+	response["data"] = images
+	response["next"] = next
+	response["prev"] = prev
+	c.JSON(http.StatusOK, response)
+}
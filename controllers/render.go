@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"imageApi/imaging"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Rendered images are cached below RENDER_CACHE_DIR, which is kept under
+// RENDER_CACHE_MAX_BYTES by evicting the least recently used renders. Widths
+// and heights are limited to RENDER_SIZES so clients cannot fill the cache
+// with arbitrary sizes.
+const (
+	defaultRenderCacheDir      = "render-cache"
+	defaultRenderCacheMaxBytes = 512 << 20
+	defaultRenderSizes         = "64,128,256,320,480,640,800,1024,1280,1600,1920,2048"
+)
+
+func renderCacheDir() string {
+	return getEnv("RENDER_CACHE_DIR", defaultRenderCacheDir)
+}
+
+func renderCacheMaxBytes() int64 {
+	return getEnvInt("RENDER_CACHE_MAX_BYTES", defaultRenderCacheMaxBytes)
+}
+
+func renderSizes() []int {
+	var sizes []int
+	for _, value := range strings.Split(getEnv("RENDER_SIZES", defaultRenderSizes), ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(value))
+		if err == nil && size > 0 {
+			sizes = append(sizes, size)
+		}
+	}
+	return sizes
+}
+
+// renderOptions is a parsed render request.
+type renderOptions struct {
+	Width   int
+	Height  int
+	Fit     string
+	Format  string
+	Quality int
+}
+
+func parseRenderOptions(c *gin.Context) (renderOptions, error) {
+	options := renderOptions{
+		Fit:     imaging.Contain,
+		Format:  imaging.JPEG,
+		Quality: thumbnailQuality()}
+	sizes := renderSizes()
+
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{{"w", &options.Width}, {"h", &options.Height}} {
+		value := c.Query(param.name)
+		if value == "" {
+			continue
+		}
+		size, err := strconv.Atoi(value)
+		if err != nil || !containsInt(sizes, size) {
+			return options, fmt.Errorf("%s must be one of %v", param.name, sizes)
+		}
+		*param.value = size
+	}
+	if options.Width == 0 && options.Height == 0 {
+		return options, fmt.Errorf("w or h is required")
+	}
+
+	if value := c.Query("fit"); value != "" {
+		options.Fit = strings.ToLower(value)
+		if options.Fit != imaging.Contain && options.Fit != imaging.Cover {
+			return options, fmt.Errorf("fit must be contain or cover")
+		}
+	}
+
+	if value := c.Query("format"); value != "" {
+		options.Format = strings.ToLower(value)
+		if options.Format == "jpg" {
+			options.Format = imaging.JPEG
+		}
+		if !imaging.ValidFormat(options.Format) {
+			return options, fmt.Errorf("format must be jpeg, png or webp")
+		}
+	}
+
+	if value := c.Query("quality"); value != "" {
+		quality, err := strconv.Atoi(value)
+		if err != nil || quality < 1 || quality > 100 {
+			return options, fmt.Errorf("quality must be between 1 and 100")
+		}
+		options.Quality = quality
+	}
+	// Only JPEG is lossy, so the quality must not split the cache for
+	// other formats.
+	if options.Format != imaging.JPEG {
+		options.Quality = 0
+	}
+
+	return options, nil
+}
+
+// renderKey names the cached render of a source file. The file's size and
+// modification time are part of the key so a replaced file is never served
+// from a stale render.
+func renderKey(imageID int, info os.FileInfo, options renderOptions) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d|%d|%d|%d|%d|%s|%s|%d",
+		imageID, info.Size(), info.ModTime().UnixNano(),
+		options.Width, options.Height, options.Fit, options.Format, options.Quality)))
+	return hex.EncodeToString(sum[:]) + imaging.Extension(options.Format)
+}
+
+// Render an image
+// RenderImage                godoc
+// @Summary      Render an image at a requested size and format
+// @Description  Returns the image whose ID value matches the image_id rotated upright, scaled to fit inside (contain) or fill (cover) the w by h box, and converted to the requested format. Images are never enlarged. Renders are cached.
+// @Tags         images
+// @Produce      jpeg,png,webp
+// @Param        image_id  path   int     true   "image ID"
+// @Param        w         query  int     false  "width in pixels, one of RENDER_SIZES"
+// @Param        h         query  int     false  "height in pixels, one of RENDER_SIZES"
+// @Param        fit       query  string  false  "contain (default) or cover"
+// @Param        format    query  string  false  "jpeg (default), png or webp"
+// @Param        quality   query  int     false  "JPEG quality, 1-100"
+// @Success      200
+// @Router       /images/{image_id}/render [get]
+func (ic *ImageController) RenderImage(c *gin.Context) {
+	image, ok := ic.findImage(c)
+	if !ok {
+		return
+	}
+
+	options, err := parseRenderOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The resolved path is used from here on, so a link changed after the
+	// check cannot lead out of the library.
+	file, err := resolveLibraryPath(image.ImageDirLocation)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	info, err := os.Stat(file)
+	if os.IsNotExist(err) {
+		respondError(c, newImageError(ErrCodeFileNotFound, image.ImageDirLocation, err))
+		return
+	}
+	if err != nil {
+		respondError(c, newImageError(ErrCodeFileUnreadable, image.ImageDirLocation, err))
+		return
+	}
+
+	key := renderKey(image.ImageID, info, options)
+	path, ok := ic.RenderCache.Get(key)
+	if !ok {
+		img, err := decodeImageFile(file, image.ImageDirLocation)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		rendered := imaging.Render(img, options.Width, options.Height, options.Fit)
+		path, err = ic.RenderCache.Put(key, func(w io.Writer) error {
+			return imaging.Encode(w, rendered, options.Format, options.Quality)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.Header("Content-Type", imaging.ContentType(options.Format))
+	c.Header("ETag", `"`+strings.TrimSuffix(key, imaging.Extension(options.Format))+`"`)
+	c.File(path)
+}
@@ -0,0 +1,331 @@
+package controllers
+
+import (
+	"fmt"
+	"imageApi/imaging"
+	"imageApi/models"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Perceptual hashes up to max_distance bits apart are taken to be the same
+// picture. The index is rebuilt from the store once it is older than
+// similarityIndexMaxAge, which drops replaced hashes and picks up images
+// added by other processes such as the import command.
+const (
+	defaultMaxDistance    = 10
+	maxMaxDistance        = 32
+	similarityIndexMaxAge = 10 * time.Minute
+)
+
+// SimilarImage is an image and how many bits its perceptual hash differs
+// from the one searched for.
+type SimilarImage struct {
+	models.Image
+	Distance int
+}
+
+// SimilarGroup is a cluster of images that are near duplicates of each
+// other, directly or through other images in the group.
+type SimilarGroup struct {
+	Images []models.Image
+}
+
+// similarityIndex is a BK-tree over the perceptual hashes of all images.
+// Images are added as they are stored; matches are always checked against
+// the stored image, so deleted images and replaced hashes left in the tree
+// do no harm.
+type similarityIndex struct {
+	mu      sync.Mutex
+	tree    *imaging.BKTree
+	builtAt time.Time
+}
+
+// add indexes a stored image. It does nothing before the index is built,
+// since building reads every image anyway.
+func (index *similarityIndex) add(image models.Image) {
+	hash, ok := parsePerceptualHash(image.ImagePerceptualHash)
+	if !ok {
+		return
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	if index.tree != nil {
+		index.tree.Add(hash, image.ImageID)
+	}
+}
+
+// search returns the IDs of the images whose hash may be within maxDistance
+// of hash, building the index first when needed.
+func (index *similarityIndex) search(store models.ImageStore, hash uint64, maxDistance int) ([]imaging.BKMatch, error) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	if index.tree == nil || time.Since(index.builtAt) > similarityIndexMaxAge {
+		images, _, err := store.Search(models.ImageQuery{})
+		if err != nil {
+			return nil, err
+		}
+
+		tree := &imaging.BKTree{}
+		for _, image := range images {
+			if hash, ok := parsePerceptualHash(image.ImagePerceptualHash); ok {
+				tree.Add(hash, image.ImageID)
+			}
+		}
+		index.tree, index.builtAt = tree, time.Now()
+	}
+
+	return index.tree.Search(hash, maxDistance), nil
+}
+
+// perceptualHash decodes the image file at path and returns its hex encoded
+// difference hash. Formats that cannot be decoded get no hash.
+func perceptualHash(path string) string {
+	img, err := imaging.Open(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%016x", imaging.DHash(img))
+}
+
+func parsePerceptualHash(value string) (uint64, bool) {
+	if value == "" {
+		return 0, false
+	}
+	hash, err := strconv.ParseUint(value, 16, 64)
+	return hash, err == nil
+}
+
+// parseMaxDistance reads the max_distance query parameter.
+func parseMaxDistance(c *gin.Context) (int, error) {
+	value := c.Query("max_distance")
+	if value == "" {
+		return defaultMaxDistance, nil
+	}
+
+	maxDistance, err := strconv.Atoi(value)
+	if err != nil || maxDistance < 0 || maxDistance > maxMaxDistance {
+		return 0, fmt.Errorf("max_distance must be between 0 and %d", maxMaxDistance)
+	}
+	return maxDistance, nil
+}
+
+// Find similar images
+// SimilarImages             godoc
+// @Summary      Get images that look like an image
+// @Description  Responds with the visible images whose perceptual hash is at most max_distance bits from the image's, nearest first. Finds resized, recompressed and lightly edited copies.
+// @Tags         images
+// @Produce      json
+// @Param        image_id      path   int  true   "image to compare with"
+// @Param        max_distance  query  int  false  "maximum differing hash bits (default 10, max 32)"
+// @Param        limit         query  int  false  "maximum number of images (default 50, max 500)"
+// @Success      200  {array}  SimilarImage
+// @Router       /images/{image_id}/similar [get]
+func (ic *ImageController) SimilarImages(c *gin.Context) {
+	image, ok := ic.findImage(c)
+	if !ok {
+		return
+	}
+
+	maxDistance, err := parseMaxDistance(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit := defaultPageLimit
+	if value := c.Query("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxPageLimit)})
+			return
+		}
+	}
+
+	hash, ok := parsePerceptualHash(image.ImagePerceptualHash)
+	if !ok {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Image has no perceptual hash, reindex it first"})
+		return
+	}
+
+	matches, err := ic.similarity.search(ic.Store, hash, maxDistance)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	similar := []SimilarImage{}
+	seen := map[int]bool{image.ImageID: true}
+	for _, match := range matches {
+		if seen[match.ID] {
+			continue
+		}
+		seen[match.ID] = true
+
+		other, err := ic.Store.Get(match.ID)
+		if err == models.ErrImageNotFound {
+			continue
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !isAdmin(c) && !other.VisibleTo(currentUserID(c)) {
+			continue
+		}
+
+		otherHash, ok := parsePerceptualHash(other.ImagePerceptualHash)
+		if !ok {
+			continue
+		}
+		if distance := imaging.HashDistance(hash, otherHash); distance <= maxDistance {
+			similar = append(similar, SimilarImage{Image: other, Distance: distance})
+		}
+	}
+
+	sort.Slice(similar, func(i, j int) bool {
+		if similar[i].Distance != similar[j].Distance {
+			return similar[i].Distance < similar[j].Distance
+		}
+		return similar[i].ImageID < similar[j].ImageID
+	})
+	if len(similar) > limit {
+		similar = similar[:limit]
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": similar})
+}
+
+// Report near duplicates
+// NearDuplicates             godoc
+// @Summary      List clusters of near duplicate images
+// @Description  Groups the visible images whose perceptual hashes are at most max_distance bits apart, largest group first. Takes the same filters as /images.
+// @Tags         images
+// @Produce      json
+// @Param        max_distance  query  int  false  "maximum differing hash bits (default 10, max 32)"
+// @Param        limit         query  int  false  "groups per page (default 50, max 500)"
+// @Param        page          query  int  false  "page number"
+// @Success      200  {array}  SimilarGroup
+// @Router       /images/near-duplicates [get]
+func (ic *ImageController) NearDuplicates(c *gin.Context) {
+	list, err := parseImageList(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	query := list.Query
+	if query.Cursor != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cursor is not supported for near duplicates"})
+		return
+	}
+
+	maxDistance, err := parseMaxDistance(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	images, _, err := ic.Store.Search(models.ImageQuery{Filter: query.Filter})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	groups, err := ic.clusterImages(images, maxDistance)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	total := len(groups)
+	if query.Offset >= len(groups) {
+		groups = []SimilarGroup{}
+	} else {
+		groups = groups[query.Offset:]
+	}
+	if len(groups) > query.Limit {
+		groups = groups[:query.Limit]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":  groups,
+		"total": total,
+		"limit": query.Limit,
+		"page":  query.Offset/query.Limit + 1})
+}
+
+// clusterImages joins images into groups of near duplicates. Each image is
+// looked up in the index once and joined with the matches among images, so
+// the work grows with the number of matches rather than with every pair.
+func (ic *ImageController) clusterImages(images []models.Image, maxDistance int) ([]SimilarGroup, error) {
+	hashes := map[int]uint64{}
+	for _, image := range images {
+		if hash, ok := parsePerceptualHash(image.ImagePerceptualHash); ok {
+			hashes[image.ImageID] = hash
+		}
+	}
+
+	parents := map[int]int{}
+	var root func(id int) int
+	root = func(id int) int {
+		parent, ok := parents[id]
+		if !ok || parent == id {
+			return id
+		}
+		parents[id] = root(parent)
+		return parents[id]
+	}
+
+	for id, hash := range hashes {
+		matches, err := ic.similarity.search(ic.Store, hash, maxDistance)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, match := range matches {
+			// Only join images in the filtered set, and only on their
+			// current hash.
+			otherHash, ok := hashes[match.ID]
+			if !ok || match.ID == id || imaging.HashDistance(hash, otherHash) > maxDistance {
+				continue
+			}
+			if a, b := root(id), root(match.ID); a != b {
+				parents[b] = a
+			}
+		}
+	}
+
+	members := map[int][]models.Image{}
+	for _, image := range images {
+		if _, ok := hashes[image.ImageID]; ok {
+			id := root(image.ImageID)
+			members[id] = append(members[id], image)
+		}
+	}
+
+	groups := []SimilarGroup{}
+	for _, group := range members {
+		if len(group) > 1 {
+			sort.Slice(group, func(i, j int) bool {
+				return group[i].ImageID < group[j].ImageID
+			})
+			groups = append(groups, SimilarGroup{Images: group})
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if len(groups[i].Images) != len(groups[j].Images) {
+			return len(groups[i].Images) > len(groups[j].Images)
+		}
+		return groups[i].Images[0].ImageID < groups[j].Images[0].ImageID
+	})
+	return groups, nil
+}
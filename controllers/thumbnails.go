@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"fmt"
+	"image"
+	"imageApi/imaging"
+	"imageApi/models"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Thumbnails are cached below THUMBNAIL_DIR as <image_id>/<size>.<ext> for
+// every size in THUMBNAIL_SIZES (long edge in pixels) and every format in
+// THUMBNAIL_FORMATS.
+const (
+	defaultThumbnailDir     = "thumbnails"
+	defaultThumbnailSizes   = "128,512,1024"
+	defaultThumbnailFormats = "jpeg,webp"
+	defaultThumbnailQuality = 85
+)
+
+func thumbnailDir() string {
+	return getEnv("THUMBNAIL_DIR", defaultThumbnailDir)
+}
+
+func thumbnailSizes() []int {
+	var sizes []int
+	for _, value := range strings.Split(getEnv("THUMBNAIL_SIZES", defaultThumbnailSizes), ",") {
+		size, err := strconv.Atoi(strings.TrimSpace(value))
+		if err == nil && size > 0 {
+			sizes = append(sizes, size)
+		}
+	}
+	return sizes
+}
+
+func thumbnailFormats() []string {
+	var formats []string
+	for _, value := range strings.Split(getEnv("THUMBNAIL_FORMATS", defaultThumbnailFormats), ",") {
+		format := strings.ToLower(strings.TrimSpace(value))
+		if format == "jpg" {
+			format = imaging.JPEG
+		}
+		if imaging.ValidFormat(format) {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+func thumbnailQuality() int {
+	return int(getEnvInt("THUMBNAIL_QUALITY", defaultThumbnailQuality))
+}
+
+func thumbnailPath(imageID int, size int, format string) string {
+	return filepath.Join(thumbnailDir(), strconv.Itoa(imageID), strconv.Itoa(size)+imaging.Extension(format))
+}
+
+// Get an image thumbnail
+// FindThumbnail                godoc
+// @Summary      Get a thumbnail of an image
+// @Description  Returns a thumbnail of the image whose ID value matches the image_id, generating it if it is missing.
+// @Tags         images
+// @Produce      jpeg,png,webp
+// @Param        image_id  path   int     true   "image ID"
+// @Param        size      query  int     false  "long edge in pixels, one of THUMBNAIL_SIZES"
+// @Param        format    query  string  false  "jpeg or webp, one of THUMBNAIL_FORMATS"
+// @Success      200
+// @Router       /images/{image_id}/thumbnail [get]
+func (ic *ImageController) FindThumbnail(c *gin.Context) {
+	image, ok := ic.findImage(c)
+	if !ok {
+		return
+	}
+
+	sizes := thumbnailSizes()
+	formats := thumbnailFormats()
+	if len(sizes) == 0 || len(formats) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Thumbnails are disabled"})
+		return
+	}
+
+	size := sizes[0]
+	if value := c.Query("size"); value != "" {
+		size, _ = strconv.Atoi(value)
+		if !containsInt(sizes, size) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("size must be one of %v", sizes)})
+			return
+		}
+	}
+
+	format := formats[0]
+	if value := c.Query("format"); value != "" {
+		format = strings.ToLower(value)
+		if !containsString(formats, format) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("format must be one of %v", formats)})
+			return
+		}
+	}
+
+	path := thumbnailPath(image.ImageID, size, format)
+
+	// Thumbnails that were never made or have been cleaned up are
+	// regenerated on demand.
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		img, err := openForThumbnail(image)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+
+		if err := writeThumbnail(path, imaging.Fit(img, size), format); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.Header("Content-Type", imaging.ContentType(format))
+	c.File(path)
+}
+
+// generateThumbnails writes every configured thumbnail for image. Failures
+// are logged rather than returned because a missing thumbnail is made again
+// when it is first requested.
+func generateThumbnails(image models.Image) {
+	sizes := thumbnailSizes()
+	formats := thumbnailFormats()
+	if len(sizes) == 0 || len(formats) == 0 {
+		return
+	}
+
+	img, err := openForThumbnail(image)
+	if err != nil {
+		log.Printf("error generating thumbnails for image %d: %v", image.ImageID, err)
+		return
+	}
+
+	for _, size := range sizes {
+		thumbnail := imaging.Fit(img, size)
+		for _, format := range formats {
+			if err := writeThumbnail(thumbnailPath(image.ImageID, size, format), thumbnail, format); err != nil {
+				log.Printf("error generating thumbnails for image %d: %v", image.ImageID, err)
+			}
+		}
+	}
+}
+
+// removeThumbnails deletes the cached thumbnails of an image.
+func removeThumbnails(imageID int) {
+	if err := os.RemoveAll(filepath.Join(thumbnailDir(), strconv.Itoa(imageID))); err != nil {
+		log.Printf("error removing thumbnails for image %d: %v", imageID, err)
+	}
+}
+
+// openForThumbnail decodes the file of image, which must be in the library.
+func openForThumbnail(image models.Image) (image.Image, error) {
+	path, err := resolveLibraryPath(image.ImageDirLocation)
+	if err != nil {
+		return nil, err
+	}
+	return decodeImageFile(path, image.ImageDirLocation)
+}
+
+// decodeImageFile decodes the already resolved path, reporting errors
+// against the stored location.
+func decodeImageFile(path string, location string) (image.Image, error) {
+	img, err := imaging.Open(path)
+	if os.IsNotExist(err) {
+		return nil, newImageError(ErrCodeFileNotFound, location, err)
+	}
+	if err != nil {
+		return nil, newImageError(ErrCodeUnsupported, location, err)
+	}
+	return img, nil
+}
+
+// writeThumbnail encodes img into path through a temporary file so readers
+// never see a partly written thumbnail.
+func writeThumbnail(path string, img image.Image, format string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".thumbnail-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := imaging.Encode(tmp, img, format, thumbnailQuality()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
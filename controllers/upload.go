@@ -0,0 +1,238 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"imageApi/models"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Uploaded files are written below IMAGE_STORAGE_ROOT and may be at most
+// UPLOAD_MAX_BYTES long.
+const (
+	defaultStorageRoot    = "uploads"
+	defaultUploadMaxBytes = 50 << 20
+)
+
+func storageRoot() string {
+	return getEnv("IMAGE_STORAGE_ROOT", defaultStorageRoot)
+}
+
+func uploadMaxBytes() int64 {
+	return getEnvInt("UPLOAD_MAX_BYTES", defaultUploadMaxBytes)
+}
+
+// Upload an image
+// UploadImage             godoc
+// @Summary      Upload and store a new image
+// @Description  Takes a multipart image file, stores it under the storage root and indexes it. Return saved JSON.
+// @Tags         images
+// @Accept       multipart/form-data
+// @Produce      json
+// @Param        image         formData  file    true   "Image file"
+// @Param        visibility    formData  string  false  "private (default), shared or public"
+// @Param        on_duplicate  query     string  false  "reject (default), link or force when the same file is already indexed"
+// @Success      200   {object}  models.Image
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Router       /images/upload [post]
+func (ic *ImageController) UploadImage(c *gin.Context) {
+	maxBytes := uploadMaxBytes()
+
+	// Leave some room for the multipart framing around the file itself.
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes+1<<20)
+
+	header, err := c.FormFile("image")
+	if err != nil && bodyTooLarge(err) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File is larger than %d bytes", maxBytes)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if header.Size > maxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File is larger than %d bytes", maxBytes)})
+		return
+	}
+
+	visibility := c.DefaultPostForm("visibility", models.VisibilityPrivate)
+	if !models.ValidVisibility(visibility) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "visibility must be private, shared or public"})
+		return
+	}
+
+	onDuplicate, err := parseOnDuplicate(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	src, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer src.Close()
+
+	root := storageRoot()
+	if err := os.MkdirAll(root, 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Stream into a temporary file first so nothing is kept until the
+	// contents have been checked.
+	tmp, err := os.CreateTemp(root, ".upload-*")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	written, err := io.Copy(tmp, io.LimitReader(src, maxBytes+1))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if written > maxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File is larger than %d bytes", maxBytes)})
+		return
+	}
+
+	isImage, err := testImageFile(tmp)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	if !isImage {
+		respondError(c, newImageError(ErrCodeNotImage, header.Filename, fmt.Errorf("not an image file")))
+		return
+	}
+
+	if err := tmp.Close(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	location, err := storeUpload(tmp.Name(), root, header.Filename)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	imageData, err := processImage(CreateImageInput{ImageDirLocation: location, ImageVisibility: visibility})
+	if err != nil {
+		os.Remove(location)
+		respondError(c, err)
+		return
+	}
+
+	image := newImage(imageData)
+	image.ImageOwnerID = currentUserID(c)
+
+	// Nothing new is kept for a duplicate, whether it is rejected or linked.
+	existing, err := ic.checkDuplicate(image, onDuplicate, imageAccess(c))
+	if err != nil {
+		os.Remove(location)
+		respondError(c, err)
+		return
+	}
+	if existing != nil {
+		os.Remove(location)
+		c.JSON(http.StatusOK, gin.H{"data": existing})
+		return
+	}
+
+	if err := ic.Store.Create(&image); err != nil {
+		os.Remove(location)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ic.similarity.add(image)
+	generateThumbnails(image)
+
+	c.JSON(http.StatusOK, gin.H{"data": image})
+}
+
+// storeUpload moves the temporary upload into root under the client's file
+// name, adding a numeric suffix when that name is already taken.
+func storeUpload(tmpName string, root string, fileName string) (string, error) {
+	base := filepath.Base(strings.ReplaceAll(fileName, `\`, "/"))
+	if base == "." || base == "/" {
+		base = "upload"
+	} else if strings.HasPrefix(base, ".") {
+		base = "upload" + base
+	}
+
+	ext := filepath.Ext(base)
+	name := strings.TrimSuffix(base, ext)
+
+	for i := 0; ; i++ {
+		candidate := base
+		if i > 0 {
+			candidate = fmt.Sprintf("%s_%d%s", name, i, ext)
+		}
+
+		location := filepath.Join(root, candidate)
+
+		// Link rather than rename so an existing file is never replaced.
+		// File systems without hard links get a copy.
+		err := os.Link(tmpName, location)
+		if err != nil && !os.IsExist(err) {
+			err = copyNewFile(tmpName, location)
+		}
+		if err == nil {
+			return location, nil
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+	}
+}
+
+// copyNewFile copies src to dst, which must not exist yet.
+func copyNewFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// bodyTooLarge reports whether err comes from reading a request body past
+// its http.MaxBytesReader limit. The multipart reader does not always wrap
+// the error, so its message is checked too.
+func bodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr) || strings.Contains(err.Error(), "request body too large")
+}
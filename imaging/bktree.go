@@ -0,0 +1,84 @@
+package imaging
+
+// BKTree indexes 64 bit hashes by their Hamming distance, so the hashes near
+// a given one are found without comparing it against every hash. The zero
+// value is an empty tree.
+type BKTree struct {
+	root *bkNode
+	size int
+}
+
+type bkNode struct {
+	hash     uint64
+	ids      []int
+	children map[int]*bkNode
+}
+
+// BKMatch is an ID found by Search and the distance of its hash.
+type BKMatch struct {
+	ID       int
+	Distance int
+}
+
+// Add indexes hash under id. IDs with equal hashes share a node.
+func (t *BKTree) Add(hash uint64, id int) {
+	t.size++
+	if t.root == nil {
+		t.root = &bkNode{hash: hash, ids: []int{id}}
+		return
+	}
+
+	node := t.root
+	for {
+		distance := HashDistance(hash, node.hash)
+		if distance == 0 {
+			node.ids = append(node.ids, id)
+			return
+		}
+
+		child, ok := node.children[distance]
+		if !ok {
+			if node.children == nil {
+				node.children = map[int]*bkNode{}
+			}
+			node.children[distance] = &bkNode{hash: hash, ids: []int{id}}
+			return
+		}
+		node = child
+	}
+}
+
+// Search returns every indexed ID whose hash is at most maxDistance bits
+// from hash. By the triangle inequality only the children whose edge is
+// within maxDistance of the node's own distance can hold matches.
+func (t *BKTree) Search(hash uint64, maxDistance int) []BKMatch {
+	var matches []BKMatch
+	if t.root == nil {
+		return matches
+	}
+
+	stack := []*bkNode{t.root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		distance := HashDistance(hash, node.hash)
+		if distance <= maxDistance {
+			for _, id := range node.ids {
+				matches = append(matches, BKMatch{ID: id, Distance: distance})
+			}
+		}
+
+		for edge, child := range node.children {
+			if edge >= distance-maxDistance && edge <= distance+maxDistance {
+				stack = append(stack, child)
+			}
+		}
+	}
+	return matches
+}
+
+// Len returns the number of IDs in the tree.
+func (t *BKTree) Len() int {
+	return t.size
+}
@@ -0,0 +1,112 @@
+package imaging
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestBKTreeSearch(t *testing.T) {
+	var tree BKTree
+	hashes := map[int]uint64{
+		1: 0x0000000000000000,
+		2: 0x0000000000000001, // 1 bit from 1
+		3: 0x0000000000000003, // 2 bits from 1
+		4: 0x000000000000000f, // 4 bits from 1
+		5: 0x00000000000000ff, // 8 bits from 1
+		6: 0xffffffffffffffff, // 64 bits from 1
+		7: 0x0000000000000001, // same hash as 2
+	}
+	for id := 1; id <= len(hashes); id++ {
+		tree.Add(hashes[id], id)
+	}
+
+	tests := []struct {
+		name        string
+		hash        uint64
+		maxDistance int
+		want        []BKMatch
+	}{
+		{"exact", 0x0, 0, []BKMatch{{1, 0}}},
+		{"equal hashes", 0x1, 0, []BKMatch{{2, 0}, {7, 0}}},
+		{"radius 1", 0x0, 1, []BKMatch{{1, 0}, {2, 1}, {7, 1}}},
+		{"radius 2", 0x0, 2, []BKMatch{{1, 0}, {2, 1}, {3, 2}, {7, 1}}},
+		{"radius 4", 0x0, 4, []BKMatch{{1, 0}, {2, 1}, {3, 2}, {4, 4}, {7, 1}}},
+		{"radius 7 leaves out 8 bits", 0x0, 7, []BKMatch{{1, 0}, {2, 1}, {3, 2}, {4, 4}, {7, 1}}},
+		{"from the other end", 0xfffffffffffffffe, 1, []BKMatch{{6, 1}}},
+		{"nothing near", 0x00ff00ff00ff0000, 3, nil},
+		{"everything", 0x0, 64, []BKMatch{{1, 0}, {2, 1}, {3, 2}, {4, 4}, {5, 8}, {6, 64}, {7, 1}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := sortedMatches(tree.Search(test.hash, test.maxDistance))
+			if !equalMatches(got, test.want) {
+				t.Errorf("Search(%#x, %d) = %v, want %v", test.hash, test.maxDistance, got, test.want)
+			}
+		})
+	}
+
+	if tree.Len() != len(hashes) {
+		t.Errorf("Len() = %d, want %d", tree.Len(), len(hashes))
+	}
+}
+
+// The tree must find exactly what comparing against every hash finds.
+func TestBKTreeSearchMatchesLinearScan(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	var tree BKTree
+	hashes := make([]uint64, 500)
+	for id := range hashes {
+		// Flip a few bits of a handful of bases so hashes cluster the way
+		// perceptual hashes of similar images do.
+		hash := []uint64{0, 0xf0f0f0f0f0f0f0f0, 0x123456789abcdef0}[random.Intn(3)]
+		for i := random.Intn(12); i > 0; i-- {
+			hash ^= 1 << uint(random.Intn(64))
+		}
+		hashes[id] = hash
+		tree.Add(hash, id)
+	}
+
+	for _, maxDistance := range []int{0, 1, 3, 6, 10, 20} {
+		for i := 0; i < 20; i++ {
+			hash := hashes[random.Intn(len(hashes))] ^ 1<<uint(random.Intn(64))
+
+			var want []BKMatch
+			for id, other := range hashes {
+				if distance := HashDistance(hash, other); distance <= maxDistance {
+					want = append(want, BKMatch{ID: id, Distance: distance})
+				}
+			}
+
+			got := sortedMatches(tree.Search(hash, maxDistance))
+			if !equalMatches(got, want) {
+				t.Fatalf("Search(%#x, %d) found %d matches, want %d", hash, maxDistance, len(got), len(want))
+			}
+		}
+	}
+}
+
+func TestBKTreeEmpty(t *testing.T) {
+	var tree BKTree
+	if matches := tree.Search(0, 64); len(matches) != 0 {
+		t.Errorf("Search on an empty tree = %v, want none", matches)
+	}
+}
+
+func sortedMatches(matches []BKMatch) []BKMatch {
+	sort.Slice(matches, func(i, j int) bool { return matches[i].ID < matches[j].ID })
+	return matches
+}
+
+func equalMatches(a []BKMatch, b []BKMatch) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
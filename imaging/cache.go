@@ -0,0 +1,164 @@
+package imaging
+
+import (
+	"container/list"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DiskCache keeps files in a directory up to a total size, evicting the
+// least recently used ones first. The access order survives restarts through
+// the files' modification times.
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	loaded  bool
+	size    int64
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	name string
+	size int64
+}
+
+func NewDiskCache(dir string, maxBytes int64) *DiskCache {
+	return &DiskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  map[string]*list.Element{}}
+}
+
+// Get returns the path of the cached file name and marks it as recently
+// used.
+func (c *DiskCache) Get(name string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+
+	element, ok := c.entries[name]
+	if !ok {
+		return "", false
+	}
+
+	path := filepath.Join(c.dir, name)
+	if _, err := os.Stat(path); err != nil {
+		c.remove(element)
+		return "", false
+	}
+
+	c.order.MoveToFront(element)
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return path, true
+}
+
+// Put stores the output of write under name, evicts old files to stay within
+// the size limit and returns the cached file's path.
+func (c *DiskCache) Put(name string, write func(w io.Writer) error) (string, error) {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(c.dir, ".cache-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(tmp.Name())
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(c.dir, name)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+
+	if element, ok := c.entries[name]; ok {
+		c.size -= element.Value.(*cacheEntry).size
+		c.order.Remove(element)
+	}
+	c.entries[name] = c.order.PushFront(&cacheEntry{name: name, size: info.Size()})
+	c.size += info.Size()
+
+	// Never evict the file just written, even when it alone is over the
+	// limit.
+	for c.size > c.maxBytes && c.order.Len() > 1 {
+		oldest := c.order.Back()
+		os.Remove(filepath.Join(c.dir, oldest.Value.(*cacheEntry).name))
+		c.remove(oldest)
+	}
+	return path, nil
+}
+
+func (c *DiskCache) remove(element *list.Element) {
+	entry := element.Value.(*cacheEntry)
+	c.size -= entry.size
+	c.order.Remove(element)
+	delete(c.entries, entry.name)
+}
+
+// load indexes the files already in the directory, most recently used
+// first, the first time the cache is used.
+func (c *DiskCache) load() {
+	if c.loaded {
+		return
+	}
+	c.loaded = true
+
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("error reading cache directory %s: %v", c.dir, err)
+		}
+		return
+	}
+
+	type file struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.Type().IsRegular() || dirEntry.Name()[0] == '.' {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, file{name: dirEntry.Name(), size: info.Size(), modTime: info.ModTime()})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.After(files[j].modTime)
+	})
+	for _, f := range files {
+		c.entries[f.name] = c.order.PushBack(&cacheEntry{name: f.name, size: f.size})
+		c.size += f.size
+	}
+}
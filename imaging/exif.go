@@ -0,0 +1,107 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// exifOrientationTag is the EXIF tag holding the image orientation.
+const exifOrientationTag = 0x0112
+
+// maxExifScan bounds how much of a file is read looking for EXIF data.
+const maxExifScan = 1 << 20
+
+// Orientation returns the EXIF orientation (1 to 8) of the JPEG, PNG, TIFF
+// or WebP file at path, or 1 when it has none.
+func Orientation(path string) int {
+	file, err := os.Open(path)
+	if err != nil {
+		return 1
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxExifScan))
+	if err != nil {
+		return 1
+	}
+
+	if orientation := tiffOrientation(findExif(data)); orientation >= 1 && orientation <= 8 {
+		return orientation
+	}
+	return 1
+}
+
+// findExif returns the TIFF structured EXIF block inside a JPEG, PNG, TIFF or
+// WebP file, or nil.
+func findExif(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		for i := 2; i+4 <= len(data); {
+			if data[i] != 0xff {
+				return nil
+			}
+			marker := data[i+1]
+			if marker == 0xd9 || marker == 0xda {
+				return nil
+			}
+			// The length counts its own two bytes.
+			length := int(binary.BigEndian.Uint16(data[i+2:]))
+			if length < 2 {
+				return nil
+			}
+			end := i + 2 + length
+			if end > len(data) {
+				end = len(data)
+			}
+			segment := data[i+4 : end]
+			if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+				return segment[6:]
+			}
+			i += 2 + length
+		}
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		return data
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		for i := 8; i+8 <= len(data); {
+			length := int(binary.BigEndian.Uint32(data[i:]))
+			if length < 0 || i+8+length > len(data) {
+				return nil
+			}
+			if string(data[i+4:i+8]) == "eXIf" {
+				return data[i+8 : i+8+length]
+			}
+			i += 12 + length
+		}
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		for i := 12; i+8 <= len(data); {
+			length := int(binary.LittleEndian.Uint32(data[i+4:]))
+			if length < 0 || i+8+length > len(data) {
+				return nil
+			}
+			if string(data[i:i+4]) == "EXIF" {
+				return bytes.TrimPrefix(data[i+8:i+8+length], []byte("Exif\x00\x00"))
+			}
+			i += 8 + length + length&1
+		}
+	}
+	return nil
+}
+
+// tiffOrientation reads the orientation tag from the first IFD of a TIFF
+// structured block, or returns 0.
+func tiffOrientation(tiff []byte) int {
+	reader, ok := newTIFFReader(tiff)
+	if !ok {
+		return 0
+	}
+
+	for _, entry := range reader.ifd(reader.firstIFD()) {
+		if entry.tag == exifOrientationTag {
+			orientation, _ := entry.uint(0)
+			return int(orientation)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,331 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrPoolClosed is returned by ExiftoolPool.Extract once the pool is closed.
+var ErrPoolClosed = errors.New("exiftool pool is closed")
+
+// ExiftoolPool reads metadata with the exiftool program, which must be
+// installed. It is slower than NativeExtractor but reads many more formats,
+// such as camera raw files. A fixed number of exiftool processes are kept
+// running in stay-open mode and each extraction borrows an idle one, so
+// nothing is started per file.
+//
+// A process that fails an extraction is closed and started again, and idle
+// processes are checked every health interval. A slot whose process cannot
+// be started is retried on its next use.
+type ExiftoolPool struct {
+	// idle holds one entry per slot that is not in use. A nil entry is a
+	// slot without a running process.
+	idle      chan *exiftoolProcess
+	done      chan struct{}
+	closeOnce sync.Once
+	probe     string
+	timeout   time.Duration
+}
+
+// NewExiftoolPool starts size exiftool processes and, when healthInterval
+// is positive, checks the idle ones that often. A process that takes longer
+// than timeout to answer is killed and replaced.
+func NewExiftoolPool(size int, healthInterval time.Duration, timeout time.Duration) (*ExiftoolPool, error) {
+	if size < 1 {
+		size = 1
+	}
+
+	// Health checks read an empty file, which exiftool answers quickly with
+	// an error field rather than failing.
+	probe, err := os.CreateTemp("", "exiftool-probe-*")
+	if err != nil {
+		return nil, err
+	}
+	probe.Close()
+
+	pool := &ExiftoolPool{
+		idle:    make(chan *exiftoolProcess, size),
+		done:    make(chan struct{}),
+		probe:   probe.Name(),
+		timeout: timeout}
+
+	for i := 0; i < size; i++ {
+		et, err := startExiftool()
+		if err != nil {
+			for ; i < size; i++ {
+				pool.idle <- nil
+			}
+			pool.Close()
+			return nil, err
+		}
+		pool.idle <- et
+	}
+
+	if healthInterval > 0 {
+		go pool.checkHealth(healthInterval)
+	}
+	return pool, nil
+}
+
+// exiftoolBinary is the exiftool program run by the pool.
+var exiftoolBinary = "exiftool"
+
+// exiftoolProcess is an exiftool program running in stay-open mode, which
+// reads its arguments from standard input and ends the output of each
+// -execute with a {ready} line.
+type exiftoolProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+func startExiftool() (*exiftoolProcess, error) {
+	// Have exiftool print coordinates as signed decimal degrees.
+	cmd := exec.Command(exiftoolBinary, "-stay_open", "True", "-@", "-", "-common_args", "-coordFormat", "%+.8f")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error starting exiftool: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error starting exiftool: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error starting exiftool: %w", err)
+	}
+	return &exiftoolProcess{cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}, nil
+}
+
+// extract returns the tags exiftool reads from path.
+func (e *exiftoolProcess) extract(path string) (map[string]interface{}, error) {
+	if _, err := fmt.Fprintf(e.stdin, "-j\n%s\n-execute\n", path); err != nil {
+		return nil, err
+	}
+
+	var output []byte
+	for {
+		line, err := e.stdout.ReadBytes('\n')
+		if err != nil {
+			return nil, fmt.Errorf("error reading exiftool output: %w", err)
+		}
+		if string(bytes.TrimRight(line, "\r\n")) == "{ready}" {
+			break
+		}
+		output = append(output, line...)
+	}
+
+	var files []map[string]interface{}
+	if err := json.Unmarshal(output, &files); err != nil {
+		return nil, fmt.Errorf("error decoding exiftool output: %w", err)
+	}
+	if len(files) != 1 {
+		return nil, fmt.Errorf("exiftool returned %d files, want 1", len(files))
+	}
+	return files[0], nil
+}
+
+// close asks the process to exit, and kills it when it has not within a
+// second.
+func (e *exiftoolProcess) close() error {
+	fmt.Fprint(e.stdin, "-stay_open\nFalse\n")
+	e.stdin.Close()
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- e.cmd.Wait()
+	}()
+
+	select {
+	case err := <-exited:
+		return err
+	case <-time.After(time.Second):
+		e.cmd.Process.Kill()
+		<-exited
+		return errors.New("exiftool did not exit and was killed")
+	}
+}
+
+// kill stops a process that may be in the middle of an extraction and
+// waits for it to exit, which ends the extraction's read.
+func (e *exiftoolProcess) kill() {
+	e.cmd.Process.Kill()
+	e.cmd.Wait()
+}
+
+func (p *ExiftoolPool) Extract(path string) (map[string]interface{}, error) {
+	// Missing files and directories are reported without using a process.
+	// A new line would end the path early in exiftool's argument list.
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding EXIF data: %w", err)
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("error decoding EXIF data: %s is a directory", path)
+	}
+	if strings.ContainsAny(path, "\r\n") {
+		return nil, fmt.Errorf("error decoding EXIF data: %q has a new line in its path", path)
+	}
+
+	et, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+
+	fields, answered, err := p.extract(et, path)
+	if !answered {
+		p.idle <- restartExiftool()
+		return nil, fmt.Errorf("exiftool did not answer within %v", p.timeout)
+	}
+	if err != nil {
+		// The process may be out of step with its output.
+		p.replace(et)
+		return nil, fmt.Errorf("error decoding EXIF data: %w", err)
+	}
+
+	p.idle <- et
+	return fields, nil
+}
+
+// extract reads the metadata of path with et. A process that has not
+// answered within the timeout is killed and waited for, and false returned.
+func (p *ExiftoolPool) extract(et *exiftoolProcess, path string) (map[string]interface{}, bool, error) {
+	if p.timeout <= 0 {
+		fields, err := et.extract(path)
+		return fields, true, err
+	}
+
+	type result struct {
+		fields map[string]interface{}
+		err    error
+	}
+	results := make(chan result, 1)
+	go func() {
+		fields, err := et.extract(path)
+		results <- result{fields, err}
+	}()
+
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-results:
+		return r.fields, true, r.err
+	case <-timer.C:
+		et.kill()
+		return nil, false, nil
+	}
+}
+
+// get waits for an idle process, starting one for an empty slot.
+func (p *ExiftoolPool) get() (*exiftoolProcess, error) {
+	select {
+	case <-p.done:
+		return nil, ErrPoolClosed
+	default:
+	}
+
+	select {
+	case et := <-p.idle:
+		if et != nil {
+			return et, nil
+		}
+		et, err := startExiftool()
+		if err != nil {
+			p.idle <- nil
+			return nil, err
+		}
+		return et, nil
+	case <-p.done:
+		return nil, ErrPoolClosed
+	}
+}
+
+// replace closes a failed process and returns its slot with a new one.
+func (p *ExiftoolPool) replace(et *exiftoolProcess) {
+	et.close()
+	p.idle <- restartExiftool()
+}
+
+// restartExiftool starts a process for a slot, or returns nil and leaves the
+// slot empty when none can be started.
+func restartExiftool() *exiftoolProcess {
+	et, err := startExiftool()
+	if err != nil {
+		log.Println("error restarting exiftool:", err)
+		return nil
+	}
+	return et
+}
+
+// checkHealth checks each idle process every interval and fills empty
+// slots. Busy processes are checked by their own extraction.
+func (p *ExiftoolPool) checkHealth(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		idle := len(p.idle)
+		for i := 0; i < idle; i++ {
+			select {
+			case et := <-p.idle:
+				p.idle <- p.healthy(et)
+			default:
+			}
+		}
+	}
+}
+
+// healthy returns et when it can read the probe file, and otherwise a new
+// process in its place.
+func (p *ExiftoolPool) healthy(et *exiftoolProcess) *exiftoolProcess {
+	if et == nil {
+		return restartExiftool()
+	}
+
+	_, answered, err := p.extract(et, p.probe)
+	if answered && err == nil {
+		return et
+	}
+
+	log.Println("exiftool failed its health check, restarting it")
+	if answered {
+		et.close()
+	}
+	return restartExiftool()
+}
+
+// Close stops every process, waiting for the ones in use to be returned.
+// Extractions started afterwards fail with ErrPoolClosed.
+func (p *ExiftoolPool) Close() error {
+	var firstErr error
+	p.closeOnce.Do(func() {
+		close(p.done)
+
+		for i := 0; i < cap(p.idle); i++ {
+			if et := <-p.idle; et != nil {
+				if err := et.close(); err != nil && firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+
+		os.Remove(p.probe)
+	})
+	return firstErr
+}
@@ -0,0 +1,110 @@
+//go:build unix
+
+package imaging
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeExiftool is a stay-open exiftool stand in that writes its process ID
+// to pids, answers with the file type and hangs on files named hang.jpg.
+const fakeExiftool = `#!/bin/sh
+echo $$ >> "$PIDS"
+while IFS= read -r line; do
+	case "$line" in
+	-j|-stay_open) ;;
+	False) exit 0 ;;
+	-execute)
+		case "$file" in
+		*hang.jpg) exec sleep 60 ;;
+		esac
+		printf '[{"SourceFile": "%s",\n  "FileType": "JPEG"}]\n{ready}\n' "$file" ;;
+	*) file=$line ;;
+	esac
+done
+`
+
+func newFakeExiftoolPool(t *testing.T, timeout time.Duration) (*ExiftoolPool, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "exiftool")
+	if err := os.WriteFile(binary, []byte(fakeExiftool), 0755); err != nil {
+		t.Fatal(err)
+	}
+	pids := filepath.Join(dir, "pids")
+	t.Setenv("PIDS", pids)
+
+	previous := exiftoolBinary
+	exiftoolBinary = binary
+	t.Cleanup(func() { exiftoolBinary = previous })
+
+	pool, err := NewExiftoolPool(1, 0, timeout)
+	if err != nil {
+		t.Fatalf("NewExiftoolPool: %v", err)
+	}
+	t.Cleanup(func() { pool.Close() })
+	return pool, pids
+}
+
+func writeFile(t *testing.T, name string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExiftoolPoolExtract(t *testing.T) {
+	pool, _ := newFakeExiftoolPool(t, 5*time.Second)
+
+	path := writeFile(t, "a.jpg")
+	fields, err := pool.Extract(path)
+	if err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if fields["SourceFile"] != path || fields["FileType"] != "JPEG" {
+		t.Errorf("fields = %v", fields)
+	}
+
+	if _, err := pool.Extract(filepath.Join(t.TempDir(), "missing.jpg")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Extract of a missing file: err = %v, want it not to exist", err)
+	}
+}
+
+// A process that does not answer must be killed and waited for, and its
+// slot given a new process.
+func TestExiftoolPoolTimeoutKillsProcess(t *testing.T) {
+	pool, pidsFile := newFakeExiftoolPool(t, 200*time.Millisecond)
+
+	if _, err := pool.Extract(writeFile(t, "hang.jpg")); err == nil {
+		t.Fatal("Extract of a hanging file did not time out")
+	}
+
+	data, err := os.ReadFile(pidsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pids := strings.Fields(string(data))
+	if len(pids) != 2 {
+		t.Fatalf("started processes %v, want the first and its replacement", pids)
+	}
+	pid, _ := strconv.Atoi(pids[0])
+	// A process that was killed and waited for no longer exists.
+	if err := syscall.Kill(pid, 0); err != syscall.ESRCH {
+		t.Errorf("timed out process %d: kill 0 = %v, want ESRCH", pid, err)
+	}
+
+	if _, err := pool.Extract(writeFile(t, "b.jpg")); err != nil {
+		t.Errorf("Extract after the timeout: %v", err)
+	}
+}
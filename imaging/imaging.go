@@ -0,0 +1,210 @@
+// Package imaging decodes, orients, resizes and encodes images for the
+// thumbnail and rendering endpoints, and reads their metadata. Everything is
+// pure Go except the optional exiftool metadata backend.
+package imaging
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"os"
+
+	_ "image/gif"
+
+	"golang.org/x/image/draw"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// Output formats.
+const (
+	JPEG = "jpeg"
+	PNG  = "png"
+	WebP = "webp"
+)
+
+var errImageSize = errors.New("image is too large to encode")
+
+// ValidFormat reports whether format can be encoded.
+func ValidFormat(format string) bool {
+	return format == JPEG || format == PNG || format == WebP
+}
+
+// ContentType returns the MIME type of an output format.
+func ContentType(format string) string {
+	return "image/" + format
+}
+
+// Extension returns the file extension, with the dot, for an output format.
+func Extension(format string) string {
+	if format == JPEG {
+		return ".jpg"
+	}
+	return "." + format
+}
+
+// Open decodes the image file at path and turns it upright according to its
+// EXIF orientation.
+func Open(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", path, err)
+	}
+
+	return Orient(img, Orientation(path)), nil
+}
+
+// Fit scales img down so that its longer edge is at most size pixels. Images
+// that already fit are returned unchanged.
+func Fit(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return img
+	}
+
+	if width >= height {
+		width, height = size, maxInt(1, (height*size+width/2)/width)
+	} else {
+		width, height = maxInt(1, (width*size+height/2)/height), size
+	}
+	return Resize(img, width, height)
+}
+
+// Resize scales img to exactly width by height pixels.
+func Resize(img image.Image, width int, height int) image.Image {
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Rect, img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// Fit modes for Render.
+const (
+	Contain = "contain"
+	Cover   = "cover"
+)
+
+// Render scales img for a width by height box. Contain keeps the whole image
+// inside the box and Cover fills the box, cropping the overflow around the
+// center. A zero width or height follows the image's aspect ratio. Images
+// are never enlarged, so the result can be smaller than the box.
+func Render(img image.Image, width int, height int, fit string) image.Image {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	if width == 0 && height == 0 {
+		return img
+	}
+	if width == 0 {
+		width = maxInt(1, (srcWidth*height+srcHeight/2)/srcHeight)
+	}
+	if height == 0 {
+		height = maxInt(1, (srcHeight*width+srcWidth/2)/srcWidth)
+	}
+
+	if fit == Cover {
+		// Crop the source to the box's aspect ratio first.
+		cropWidth, cropHeight := srcWidth, srcWidth*height/width
+		if cropHeight > srcHeight {
+			cropWidth, cropHeight = srcHeight*width/height, srcHeight
+		}
+		cropWidth, cropHeight = maxInt(1, cropWidth), maxInt(1, cropHeight)
+
+		x := bounds.Min.X + (srcWidth-cropWidth)/2
+		y := bounds.Min.Y + (srcHeight-cropHeight)/2
+		crop := image.NewNRGBA(image.Rect(0, 0, cropWidth, cropHeight))
+		draw.Draw(crop, crop.Rect, img, image.Pt(x, y), draw.Src)
+
+		if cropWidth <= width {
+			return crop
+		}
+		return Resize(crop, width, height)
+	}
+
+	scale := math.Min(float64(width)/float64(srcWidth), float64(height)/float64(srcHeight))
+	if scale >= 1 {
+		return img
+	}
+	return Resize(img,
+		maxInt(1, int(math.Round(float64(srcWidth)*scale))),
+		maxInt(1, int(math.Round(float64(srcHeight)*scale))))
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Encode writes img in format. Quality only applies to JPEG; PNG and WebP
+// are lossless.
+func Encode(w io.Writer, img image.Image, format string, quality int) error {
+	switch format {
+	case JPEG:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case PNG:
+		return png.Encode(w, img)
+	case WebP:
+		return EncodeWebP(w, img)
+	default:
+		return fmt.Errorf("unsupported image format %q", format)
+	}
+}
+
+// Orient transforms img according to an EXIF orientation value (1 to 8) so
+// that it displays upright.
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// Orientations 5 to 8 swap the axes.
+	dstWidth, dstHeight := width, height
+	if orientation >= 5 {
+		dstWidth, dstHeight = height, width
+	}
+
+	src := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(src, src.Rect, img, bounds.Min, draw.Src)
+	dst := image.NewNRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = width-1-x, y
+			case 3: // rotated 180
+				dx, dy = width-1-x, height-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, height-1-y
+			case 5: // mirrored and rotated 270 clockwise
+				dx, dy = y, x
+			case 6: // rotated 90 clockwise
+				dx, dy = height-1-y, x
+			case 7: // mirrored and rotated 90 clockwise
+				dx, dy = height-1-y, width-1-x
+			case 8: // rotated 270 clockwise
+				dx, dy = y, width-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,372 @@
+package imaging
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupportedFormat is returned by NativeExtractor for files it cannot
+// read.
+var ErrUnsupportedFormat = errors.New("unsupported image format")
+
+// MetadataExtractor reads the metadata of an image file. Tags are named and
+// formatted the way exiftool prints them, such as "CreateDate" with
+// "2023:05:14 10:11:12", and values are strings or float64s, so every
+// backend can be used in place of the others.
+type MetadataExtractor interface {
+	Extract(path string) (map[string]interface{}, error)
+	// Close releases what the extractor holds, such as running processes.
+	Close() error
+}
+
+// fileTypes maps the formats image.DecodeConfig reports to the FileType and
+// MIMEType exiftool gives them.
+var fileTypes = map[string][2]string{
+	"jpeg": {"JPEG", "image/jpeg"},
+	"png":  {"PNG", "image/png"},
+	"tiff": {"TIFF", "image/tiff"},
+	"webp": {"WEBP", "image/webp"},
+	"gif":  {"GIF", "image/gif"},
+	"bmp":  {"BMP", "image/bmp"},
+}
+
+// EXIF tags read by NativeExtractor, by the IFD they are found in.
+var (
+	ifd0Tags = map[uint16]string{
+		0x010e: "ImageDescription",
+		0x010f: "Make",
+		0x0110: "Model",
+		0x0112: "Orientation",
+		0x0131: "Software",
+		0x0132: "ModifyDate",
+		0x013b: "Artist",
+		0x8298: "Copyright",
+	}
+	exifIFDTags = map[uint16]string{
+		0x829a: "ExposureTime",
+		0x829d: "FNumber",
+		0x8827: "ISO",
+		0x9003: "DateTimeOriginal",
+		0x9004: "CreateDate",
+		0x9010: "OffsetTime",
+		0x9011: "OffsetTimeOriginal",
+		0x9012: "OffsetTimeDigitized",
+		0x9209: "Flash",
+		0x920a: "FocalLength",
+		0x9290: "SubSecTime",
+		0x9291: "SubSecTimeOriginal",
+		0x9292: "SubSecTimeDigitized",
+		0xa002: "ExifImageWidth",
+		0xa003: "ExifImageHeight",
+		0xa403: "WhiteBalance",
+		0xa433: "LensMake",
+		0xa434: "LensModel",
+	}
+	gpsIFDTags = map[uint16]string{
+		0x0001: "GPSLatitudeRef",
+		0x0002: "GPSLatitude",
+		0x0003: "GPSLongitudeRef",
+		0x0004: "GPSLongitude",
+		0x0005: "GPSAltitudeRef",
+		0x0006: "GPSAltitude",
+		0x0007: "GPSTimeStamp",
+		0x001d: "GPSDateStamp",
+	}
+)
+
+// Pointers from IFD0 to the EXIF and GPS IFDs.
+const (
+	exifIFDPointer = 0x8769
+	gpsIFDPointer  = 0x8825
+)
+
+var orientationNames = map[uint32]string{
+	1: "Horizontal (normal)",
+	2: "Mirror horizontal",
+	3: "Rotate 180",
+	4: "Mirror vertical",
+	5: "Mirror horizontal and rotate 270 CW",
+	6: "Rotate 90 CW",
+	7: "Mirror horizontal and rotate 90 CW",
+	8: "Rotate 270 CW",
+}
+
+// flashNames are exiftool's names for the EXIF Flash values. Bit 0 is set
+// when the flash fired.
+var flashNames = map[uint32]string{
+	0x00: "No Flash",
+	0x01: "Fired",
+	0x05: "Fired, Return not detected",
+	0x07: "Fired, Return detected",
+	0x08: "On, Did not fire",
+	0x09: "On, Fired",
+	0x0d: "On, Return not detected",
+	0x0f: "On, Return detected",
+	0x10: "Off, Did not fire",
+	0x14: "Off, Did not fire, Return not detected",
+	0x18: "Auto, Did not fire",
+	0x19: "Auto, Fired",
+	0x1d: "Auto, Fired, Return not detected",
+	0x1f: "Auto, Fired, Return detected",
+	0x20: "No flash function",
+	0x30: "Off, No flash function",
+	0x41: "Fired, Red-eye reduction",
+	0x45: "Fired, Red-eye reduction, Return not detected",
+	0x47: "Fired, Red-eye reduction, Return detected",
+	0x49: "On, Red-eye reduction",
+	0x4d: "On, Red-eye reduction, Return not detected",
+	0x4f: "On, Red-eye reduction, Return detected",
+	0x50: "Off, Red-eye reduction",
+	0x58: "Auto, Did not fire, Red-eye reduction",
+	0x59: "Auto, Fired, Red-eye reduction",
+	0x5d: "Auto, Fired, Red-eye reduction, Return not detected",
+	0x5f: "Auto, Fired, Red-eye reduction, Return detected",
+}
+
+var whiteBalanceNames = map[uint32]string{
+	0: "Auto",
+	1: "Manual",
+}
+
+var gpsRefNames = map[string]string{
+	"N": "North",
+	"S": "South",
+	"E": "East",
+	"W": "West",
+}
+
+// NativeExtractor reads the EXIF metadata of JPEG, PNG, TIFF and WebP files,
+// and the size of GIF and BMP files, in pure Go. It fills the file, size and
+// EXIF tags the API uses without needing exiftool.
+type NativeExtractor struct{}
+
+func (NativeExtractor) Extract(path string) (map[string]interface{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	config, format, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+	fileType, ok := fileTypes[format]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+
+	fields := map[string]interface{}{
+		"FileName":    info.Name(),
+		"FileSize":    formatFileSize(info.Size()),
+		"FileType":    fileType[0],
+		"MIMEType":    fileType[1],
+		"ImageWidth":  float64(config.Width),
+		"ImageHeight": float64(config.Height),
+		"ImageSize":   fmt.Sprintf("%dx%d", config.Width, config.Height),
+		"Megapixels":  megapixels(config.Width, config.Height)}
+
+	// The IFDs of a TIFF file may be anywhere in it, so they are read from
+	// the file where they are. The other formats keep their EXIF block near
+	// the start.
+	if format == "tiff" {
+		if reader, ok := newTIFFReaderAt(file, info.Size()); ok {
+			readExifTags(reader, fields)
+		}
+		return fields, nil
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(file, maxExifScan))
+	if err != nil {
+		return nil, err
+	}
+
+	if reader, ok := newTIFFReader(findExif(data)); ok {
+		readExifTags(reader, fields)
+	}
+	return fields, nil
+}
+
+func (NativeExtractor) Close() error {
+	return nil
+}
+
+// readExifTags adds the known tags of IFD0 and of the EXIF and GPS IFDs it
+// points to.
+func readExifTags(reader *tiffReader, fields map[string]interface{}) {
+	ifd0 := reader.ifd(reader.firstIFD())
+	addTags(ifd0, ifd0Tags, fields)
+
+	for _, entry := range ifd0 {
+		switch entry.tag {
+		case exifIFDPointer:
+			if offset, ok := entry.uint(0); ok {
+				addTags(reader.ifd(int(offset)), exifIFDTags, fields)
+			}
+		case gpsIFDPointer:
+			if offset, ok := entry.uint(0); ok {
+				addTags(reader.ifd(int(offset)), gpsIFDTags, fields)
+			}
+		}
+	}
+}
+
+func addTags(entries []tiffEntry, names map[uint16]string, fields map[string]interface{}) {
+	for _, entry := range entries {
+		name, ok := names[entry.tag]
+		if !ok {
+			continue
+		}
+		if value, ok := tagValue(name, entry); ok {
+			fields[name] = value
+		}
+	}
+}
+
+// tagValue formats an entry the way exiftool prints the tag.
+func tagValue(name string, entry tiffEntry) (interface{}, bool) {
+	switch name {
+	case "Orientation":
+		value, _ := entry.uint(0)
+		orientation, ok := orientationNames[value]
+		return orientation, ok
+	case "Flash":
+		value, _ := entry.uint(0)
+		flash, ok := flashNames[value]
+		return flash, ok
+	case "WhiteBalance":
+		value, _ := entry.uint(0)
+		whiteBalance, ok := whiteBalanceNames[value]
+		return whiteBalance, ok
+	case "GPSLatitudeRef", "GPSLongitudeRef":
+		ref := gpsRefNames[strings.ToUpper(entry.ascii())]
+		return ref, ref != ""
+	case "GPSLatitude", "GPSLongitude":
+		degrees, ok1 := entry.number(0)
+		minutes, ok2 := entry.number(1)
+		seconds, ok3 := entry.number(2)
+		if !ok1 || !ok2 || !ok3 {
+			return nil, false
+		}
+		return fmt.Sprintf("%+.8f", degrees+minutes/60+seconds/3600), true
+	case "GPSAltitudeRef":
+		value, ok := entry.uint(0)
+		if value == 1 {
+			return "Below Sea Level", ok
+		}
+		return "Above Sea Level", ok
+	case "GPSAltitude":
+		value, ok := entry.number(0)
+		return fmt.Sprintf("%.1f m", value), ok
+	case "GPSTimeStamp":
+		hours, ok1 := entry.number(0)
+		minutes, ok2 := entry.number(1)
+		seconds, ok3 := entry.number(2)
+		if !ok1 || !ok2 || !ok3 {
+			return nil, false
+		}
+		return fmt.Sprintf("%02d:%02d:%02d", int(hours), int(minutes), int(seconds)), true
+	case "ExposureTime":
+		value, numerator, denominator, ok := entry.rational(0)
+		if !ok {
+			return nil, false
+		}
+		if value < 0.25 && numerator > 0 {
+			return fmt.Sprintf("1/%d", int64(math.Round(float64(denominator)/float64(numerator)))), true
+		}
+		return formatNumber(value), true
+	case "FNumber":
+		value, ok := entry.number(0)
+		if value < 1 {
+			return math.Round(value*100) / 100, ok
+		}
+		return math.Round(value*10) / 10, ok
+	case "FocalLength":
+		value, ok := entry.number(0)
+		return fmt.Sprintf("%.1f mm", value), ok
+	}
+
+	if entry.kind == tiffASCII {
+		value := entry.ascii()
+		return value, value != ""
+	}
+	return entry.number(0)
+}
+
+// ParseOrientation returns the EXIF orientation from 1 to 8 for a value
+// printed by exiftool, such as "Rotate 90 CW", or given as a number.
+func ParseOrientation(value string) (int, bool) {
+	for orientation, name := range orientationNames {
+		if strings.EqualFold(value, name) {
+			return int(orientation), true
+		}
+	}
+	orientation, err := strconv.Atoi(value)
+	return orientation, err == nil && orientation >= 1 && orientation <= 8
+}
+
+// FlashFired reports whether the flash fired for a Flash value printed by
+// exiftool, such as "Auto, Fired", or given as the EXIF number.
+func FlashFired(value string) (bool, bool) {
+	if n, err := strconv.ParseUint(value, 10, 16); err == nil {
+		return n&1 == 1, true
+	}
+	for n, name := range flashNames {
+		if strings.EqualFold(value, name) {
+			return n&1 == 1, true
+		}
+	}
+	return false, false
+}
+
+// formatFileSize prints a byte count the way exiftool prints FileSize.
+func formatFileSize(size int64) string {
+	value := float64(size)
+	switch {
+	case size < 2048:
+		return fmt.Sprintf("%d bytes", size)
+	case size < 10240:
+		return fmt.Sprintf("%.1f kB", value/1024)
+	case size < 2097152:
+		return fmt.Sprintf("%.0f kB", value/1024)
+	case size < 10485760:
+		return fmt.Sprintf("%.1f MB", value/1048576)
+	case size < 2147483648:
+		return fmt.Sprintf("%.0f MB", value/1048576)
+	case size < 10737418240:
+		return fmt.Sprintf("%.1f GB", value/1073741824)
+	}
+	return fmt.Sprintf("%.0f GB", value/1073741824)
+}
+
+// megapixels rounds like exiftool's Megapixels tag: one decimal from one
+// megapixel up and more below.
+func megapixels(width int, height int) float64 {
+	value := float64(width) * float64(height) / 1e6
+	scale := 1e6
+	if value >= 1 {
+		scale = 10
+	} else if value >= 0.001 {
+		scale = 1000
+	}
+	return math.Round(value*scale) / scale
+}
+
+// formatNumber prints a float without trailing zeros.
+func formatNumber(value float64) string {
+	return strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.4f", value), "0"), ".")
+}
@@ -0,0 +1,37 @@
+package imaging
+
+import (
+	"image"
+	"math/bits"
+
+	"golang.org/x/image/draw"
+)
+
+// DHash returns the 64 bit difference hash of img. The image is shrunk to 9
+// by 8 pixels and each bit tells whether a pixel is brighter than the one to
+// its right, so resized, recompressed or lightly edited copies get hashes
+// only a few bits apart.
+func DHash(img image.Image) uint64 {
+	small := image.NewNRGBA(image.Rect(0, 0, 9, 8))
+	draw.CatmullRom.Scale(small, small.Rect, img, img.Bounds(), draw.Src, nil)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if luminance(small, x, y) > luminance(small, x+1, y) {
+				hash |= 1 << uint(y*8+x)
+			}
+		}
+	}
+	return hash
+}
+
+// HashDistance is the number of bits two hashes differ in.
+func HashDistance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func luminance(img *image.NRGBA, x int, y int) int {
+	pixel := img.Pix[img.PixOffset(x, y):]
+	return 299*int(pixel[0]) + 587*int(pixel[1]) + 114*int(pixel[2])
+}
@@ -0,0 +1,210 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// TIFF field types used by EXIF.
+const (
+	tiffByte      = 1
+	tiffASCII     = 2
+	tiffShort     = 3
+	tiffLong      = 4
+	tiffRational  = 5
+	tiffUndefined = 7
+	tiffSLong     = 9
+	tiffSRational = 10
+)
+
+var tiffTypeSizes = map[uint16]int{
+	tiffByte:      1,
+	tiffASCII:     1,
+	tiffShort:     2,
+	tiffLong:      4,
+	tiffRational:  8,
+	tiffUndefined: 1,
+	tiffSLong:     4,
+	tiffSRational: 8,
+}
+
+// maxIFDEntries bounds the entries read from one IFD, so a corrupt count
+// cannot make a small file look huge.
+const maxIFDEntries = 1000
+
+// maxTIFFValueSize bounds the value read for one entry. Longer values, such
+// as maker notes, are left out unread.
+const maxTIFFValueSize = 64 << 10
+
+// tiffReader reads the IFDs of a TIFF structured block, such as the EXIF
+// data of a JPEG or a whole TIFF file. Only the IFDs and the values of their
+// entries are read. Every offset is checked, so corrupt data gives fewer
+// entries rather than a panic.
+type tiffReader struct {
+	data  io.ReaderAt
+	size  int64
+	order binary.ByteOrder
+}
+
+// tiffEntry is one IFD entry with its value bytes.
+type tiffEntry struct {
+	tag   uint16
+	kind  uint16
+	count int
+	value []byte
+	order binary.ByteOrder
+}
+
+func newTIFFReader(tiff []byte) (*tiffReader, bool) {
+	return newTIFFReaderAt(bytes.NewReader(tiff), int64(len(tiff)))
+}
+
+// newTIFFReaderAt reads the TIFF structured data of size bytes in data,
+// such as an open TIFF file.
+func newTIFFReaderAt(data io.ReaderAt, size int64) (*tiffReader, bool) {
+	reader := &tiffReader{data: data, size: size}
+	header := reader.read(0, 8)
+	if header == nil {
+		return nil, false
+	}
+
+	switch string(header[:2]) {
+	case "II":
+		reader.order = binary.LittleEndian
+	case "MM":
+		reader.order = binary.BigEndian
+	default:
+		return nil, false
+	}
+	return reader, true
+}
+
+// read returns the length bytes at offset, or nil when they are not all
+// there.
+func (r *tiffReader) read(offset int64, length int64) []byte {
+	if offset < 0 || length < 0 || offset+length > r.size {
+		return nil
+	}
+	buf := make([]byte, length)
+	if n, _ := r.data.ReadAt(buf, offset); int64(n) < length {
+		return nil
+	}
+	return buf
+}
+
+// firstIFD returns the offset of IFD0.
+func (r *tiffReader) firstIFD() int {
+	offset := r.read(4, 4)
+	if offset == nil {
+		return 0
+	}
+	return int(r.order.Uint32(offset))
+}
+
+// ifd returns the entries of the IFD at offset. Entries of unknown types or
+// with values outside the data are left out.
+func (r *tiffReader) ifd(offset int) []tiffEntry {
+	if offset < 8 {
+		return nil
+	}
+	countBytes := r.read(int64(offset), 2)
+	if countBytes == nil {
+		return nil
+	}
+
+	count := int64(r.order.Uint16(countBytes))
+	if count > maxIFDEntries {
+		count = maxIFDEntries
+	}
+	// Read the entries that are there when the count claims more.
+	if available := (r.size - int64(offset) - 2) / 12; count > available {
+		count = available
+	}
+	data := r.read(int64(offset)+2, count*12)
+
+	var entries []tiffEntry
+	for start := 0; start+12 <= len(data); start += 12 {
+		entry := tiffEntry{
+			tag:   r.order.Uint16(data[start:]),
+			kind:  r.order.Uint16(data[start+2:]),
+			count: int(r.order.Uint32(data[start+4:])),
+			order: r.order}
+
+		size, ok := tiffTypeSizes[entry.kind]
+		if !ok || entry.count < 0 || int64(entry.count) > r.size {
+			continue
+		}
+
+		length := int64(size) * int64(entry.count)
+		if length <= 4 {
+			entry.value = data[start+8 : start+8+int(length)]
+		} else if length <= maxTIFFValueSize {
+			entry.value = r.read(int64(r.order.Uint32(data[start+8:])), length)
+		}
+		if entry.value == nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// ascii returns a text value without its NUL terminator and padding.
+func (e tiffEntry) ascii() string {
+	value := e.value
+	if i := bytes.IndexByte(value, 0); i >= 0 {
+		value = value[:i]
+	}
+	return string(bytes.TrimSpace(value))
+}
+
+// uint returns the i'th value of a BYTE, SHORT or LONG entry.
+func (e tiffEntry) uint(i int) (uint32, bool) {
+	if i >= e.count {
+		return 0, false
+	}
+
+	switch e.kind {
+	case tiffByte, tiffUndefined:
+		return uint32(e.value[i]), true
+	case tiffShort:
+		return uint32(e.order.Uint16(e.value[i*2:])), true
+	case tiffLong:
+		return e.order.Uint32(e.value[i*4:]), true
+	}
+	return 0, false
+}
+
+// rational returns the i'th value of a RATIONAL or SRATIONAL entry as a
+// float, and its numerator and denominator.
+func (e tiffEntry) rational(i int) (float64, int64, int64, bool) {
+	if i >= e.count || e.kind != tiffRational && e.kind != tiffSRational {
+		return 0, 0, 0, false
+	}
+
+	var numerator, denominator int64
+	if e.kind == tiffRational {
+		numerator = int64(e.order.Uint32(e.value[i*8:]))
+		denominator = int64(e.order.Uint32(e.value[i*8+4:]))
+	} else {
+		numerator = int64(int32(e.order.Uint32(e.value[i*8:])))
+		denominator = int64(int32(e.order.Uint32(e.value[i*8+4:])))
+	}
+	if denominator == 0 {
+		return 0, numerator, denominator, false
+	}
+	return float64(numerator) / float64(denominator), numerator, denominator, true
+}
+
+// number returns the i'th value of any numeric entry as a float.
+func (e tiffEntry) number(i int) (float64, bool) {
+	if value, ok := e.uint(i); ok {
+		return float64(value), true
+	}
+	if e.kind == tiffSLong && i < e.count {
+		return float64(int32(e.order.Uint32(e.value[i*4:]))), true
+	}
+	value, _, _, ok := e.rational(i)
+	return value, ok
+}
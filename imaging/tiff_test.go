@@ -0,0 +1,210 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// ifdEntry is an IFD entry for building test TIFF blocks. Values of up to
+// four bytes are stored in the entry and longer ones at offset.
+type ifdEntry struct {
+	tag    uint16
+	kind   uint16
+	count  uint32
+	value  []byte
+	offset uint32
+}
+
+// tiffBlock builds a little endian TIFF block with the IFD at ifdOffset
+// and count IFD entries claimed, which may be more than there are.
+func tiffBlock(ifdOffset uint32, count uint16, entries []ifdEntry, tail []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("II*\x00")
+	binary.Write(&buf, binary.LittleEndian, ifdOffset)
+	binary.Write(&buf, binary.LittleEndian, count)
+	for _, entry := range entries {
+		binary.Write(&buf, binary.LittleEndian, entry.tag)
+		binary.Write(&buf, binary.LittleEndian, entry.kind)
+		binary.Write(&buf, binary.LittleEndian, entry.count)
+		if entry.value != nil {
+			value := make([]byte, 4)
+			copy(value, entry.value)
+			buf.Write(value)
+		} else {
+			binary.Write(&buf, binary.LittleEndian, entry.offset)
+		}
+	}
+	buf.Write(tail)
+	return buf.Bytes()
+}
+
+// ifd builds an IFD with entries and no next IFD, for after the first.
+func ifd(entries ...ifdEntry) []byte {
+	return tiffBlock(0, uint16(len(entries)), entries, []byte{0, 0, 0, 0})[8:]
+}
+
+func short(v uint16) []byte {
+	return binary.LittleEndian.AppendUint16(nil, v)
+}
+
+func long(v uint32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, v)
+}
+
+var orientation6 = ifdEntry{tag: 0x0112, kind: tiffShort, count: 1, value: short(6)}
+
+func TestReadExifTagsMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		tiff []byte
+		// want lists tags that must still be read.
+		want map[string]interface{}
+	}{
+		{"header only", []byte("II*\x00\x08\x00\x00\x00"), nil},
+		{"IFD offset past the end", tiffBlock(0xffffffff, 0, nil, nil), nil},
+		{"IFD offset inside the header", tiffBlock(2, 1, []ifdEntry{orientation6}, nil), nil},
+		{"count larger than the data", tiffBlock(8, 500, []ifdEntry{orientation6}, nil),
+			map[string]interface{}{"Orientation": "Rotate 90 CW"}},
+		{"entry cut short", tiffBlock(8, 2, []ifdEntry{orientation6}, []byte{0x0f, 0x01, 0x02}),
+			map[string]interface{}{"Orientation": "Rotate 90 CW"}},
+		{"value offset past the end", tiffBlock(8, 2, []ifdEntry{
+			{tag: 0x010f, kind: tiffASCII, count: 20, offset: 0xfffffff0},
+			orientation6}, nil),
+			map[string]interface{}{"Orientation": "Rotate 90 CW"}},
+		{"huge count", tiffBlock(8, 1, []ifdEntry{{tag: 0x010f, kind: tiffASCII, count: 0xffffffff, offset: 8}}, nil), nil},
+		{"count overflowing the value size", tiffBlock(8, 1, []ifdEntry{{tag: 0x8298, kind: tiffRational, count: 0x20000000, offset: 8}}, nil), nil},
+		{"unknown type", tiffBlock(8, 1, []ifdEntry{{tag: 0x0112, kind: 99, count: 1, value: short(6)}}, nil), nil},
+		{"zero count", tiffBlock(8, 1, []ifdEntry{{tag: 0x0112, kind: tiffShort, count: 0}}, nil), nil},
+		{"EXIF IFD is IFD0", tiffBlock(8, 2, []ifdEntry{
+			orientation6,
+			{tag: exifIFDPointer, kind: tiffLong, count: 1, value: long(8)}}, nil),
+			map[string]interface{}{"Orientation": "Rotate 90 CW"}},
+		{"GPS IFD is IFD0", tiffBlock(8, 2, []ifdEntry{
+			orientation6,
+			{tag: gpsIFDPointer, kind: tiffLong, count: 1, value: long(8)}}, nil),
+			map[string]interface{}{"Orientation": "Rotate 90 CW"}},
+		{"pointer past the end", tiffBlock(8, 1, []ifdEntry{{tag: exifIFDPointer, kind: tiffLong, count: 1, value: long(0xfffffff0)}}, nil), nil},
+		{"pointer of the wrong type", tiffBlock(8, 1, []ifdEntry{{tag: gpsIFDPointer, kind: tiffASCII, count: 4, value: []byte("abc")}}, nil), nil},
+		// The second IFD starts at 22, after IFD0 with one entry and its
+		// next IFD offset, and holds three entries, so its values start at
+		// 22+2+3*12+4 = 64.
+		{"zero denominators", tiffBlock(8, 1, []ifdEntry{
+			{tag: exifIFDPointer, kind: tiffLong, count: 1, value: long(22)}}, append(ifd(
+			ifdEntry{tag: 0x829a, kind: tiffRational, count: 1, offset: 64},
+			ifdEntry{tag: 0x829d, kind: tiffRational, count: 1, offset: 64},
+			ifdEntry{tag: 0x8827, kind: tiffShort, count: 1, value: short(100)}),
+			1, 0, 0, 0, 0, 0, 0, 0)),
+			map[string]interface{}{"ISO": 100.0}},
+		{"GPS coordinates too short", tiffBlock(8, 1, []ifdEntry{
+			{tag: gpsIFDPointer, kind: tiffLong, count: 1, value: long(22)}}, append(ifd(
+			ifdEntry{tag: 0x0001, kind: tiffASCII, count: 2, value: []byte("N")},
+			ifdEntry{tag: 0x0002, kind: tiffRational, count: 1, offset: 64},
+			ifdEntry{tag: 0x0007, kind: tiffRational, count: 2, offset: 64}),
+			1, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 1, 0, 0, 0)),
+			map[string]interface{}{"GPSLatitudeRef": "North"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("panic: %v", r)
+				}
+			}()
+
+			reader, ok := newTIFFReader(test.tiff)
+			if !ok {
+				t.Fatal("newTIFFReader rejected the block")
+			}
+			fields := map[string]interface{}{}
+			readExifTags(reader, fields)
+			tiffOrientation(test.tiff)
+
+			for name, want := range test.want {
+				if fields[name] != want {
+					t.Errorf("%s = %v, want %v", name, fields[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestFindExifMalformed(t *testing.T) {
+	exif := tiffBlock(8, 1, []ifdEntry{orientation6}, nil)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		wantExif bool
+	}{
+		{"empty", nil, false},
+		{"JPEG marker only", []byte{0xff, 0xd8}, false},
+		{"JPEG segment length 0", []byte{0xff, 0xd8, 0xff, 0xe1, 0x00, 0x00, 'E', 'x'}, false},
+		{"JPEG segment length 1", []byte{0xff, 0xd8, 0xff, 0xe1, 0x00, 0x01, 'E', 'x'}, false},
+		{"JPEG segment past the end", append([]byte{0xff, 0xd8, 0xff, 0xe1, 0xff, 0xff}, "Exif\x00\x00II"...), true},
+		{"JPEG segment cut in its header", []byte{0xff, 0xd8, 0xff, 0xe1, 0x00}, false},
+		{"JPEG", append(append([]byte{0xff, 0xd8, 0xff, 0xe1, 0x00, byte(8 + len(exif))}, "Exif\x00\x00"...), exif...), true},
+		{"PNG chunk past the end", append([]byte("\x89PNG\r\n\x1a\n"), 0x7f, 0xff, 0xff, 0xff, 'e', 'X', 'I', 'f'), false},
+		{"WebP chunk past the end", append([]byte("RIFF\x00\x00\x00\x00WEBP"), 'E', 'X', 'I', 'F', 0xff, 0xff, 0xff, 0x7f), false},
+		{"WebP odd chunk at the end", append([]byte("RIFF\x00\x00\x00\x00WEBP"), 'V', 'P', '8', 'X', 1, 0, 0, 0, 0), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("panic: %v", r)
+				}
+			}()
+
+			found := findExif(test.data)
+			if (found != nil) != test.wantExif {
+				t.Errorf("findExif found %q, want EXIF %v", found, test.wantExif)
+			}
+			tiffOrientation(found)
+		})
+	}
+}
+
+// countingReaderAt serves a TIFF block followed by size zero bytes and
+// counts the bytes read.
+type countingReaderAt struct {
+	tiff []byte
+	size int64
+	read int64
+}
+
+func (r *countingReaderAt) ReadAt(p []byte, offset int64) (int, error) {
+	r.read += int64(len(p))
+	for i := range p {
+		p[i] = 0
+		if offset+int64(i) < int64(len(r.tiff)) {
+			p[i] = r.tiff[offset+int64(i)]
+		}
+	}
+	return len(p), nil
+}
+
+// A large TIFF file must have only its IFDs and their values read.
+func TestReadExifTagsReaderAt(t *testing.T) {
+	model := []byte("Camera model\x00")
+	data := &countingReaderAt{
+		tiff: tiffBlock(8, 2, []ifdEntry{
+			orientation6,
+			{tag: 0x0110, kind: tiffASCII, count: uint32(len(model)), offset: 38}},
+			append([]byte{0, 0, 0, 0}, model...)),
+		size: 500 << 20}
+
+	reader, ok := newTIFFReaderAt(data, data.size)
+	if !ok {
+		t.Fatal("newTIFFReaderAt rejected the file")
+	}
+	fields := map[string]interface{}{}
+	readExifTags(reader, fields)
+
+	if fields["Orientation"] != "Rotate 90 CW" || fields["Model"] != "Camera model" {
+		t.Errorf("fields = %v", fields)
+	}
+	if data.read > 1<<10 {
+		t.Errorf("read %d bytes of a %d byte file", data.read, data.size)
+	}
+}
@@ -0,0 +1,386 @@
+package imaging
+
+import (
+	"bufio"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"io"
+	"sort"
+)
+
+// EncodeWebP writes img as a lossless WebP (VP8L) image. It uses the subtract
+// green and average predictor transforms with plain Huffman coded literals,
+// which is simple and fast but leaves out the back references a full encoder
+// would add.
+func EncodeWebP(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > 1<<14 || height > 1<<14 {
+		return errImageSize
+	}
+
+	nrgba := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(nrgba, nrgba.Rect, img, bounds.Min, draw.Src)
+
+	argb := make([]uint32, width*height)
+	hasAlpha := false
+	for i := range argb {
+		p := nrgba.Pix[i*4 : i*4+4]
+		argb[i] = uint32(p[3])<<24 | uint32(p[0])<<16 | uint32(p[1])<<8 | uint32(p[2])
+		if p[3] != 0xff {
+			hasAlpha = true
+		}
+	}
+
+	bw := &bitWriter{}
+	bw.write(uint64(width-1), 14)
+	bw.write(uint64(height-1), 14)
+	if hasAlpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3) // version
+
+	// Subtract green transform.
+	bw.write(1, 1)
+	bw.write(2, 2)
+	for i, p := range argb {
+		g := (p >> 8) & 0xff
+		argb[i] = p&0xff00ff00 | ((p>>16&0xff-g)&0xff)<<16 | (p-g)&0xff
+	}
+
+	// Predictor transform using mode 7, the average of the left and top
+	// pixels, in every block.
+	const blockBits = 9
+	bw.write(1, 1)
+	bw.write(0, 2)
+	bw.write(blockBits-2, 3)
+	blocksX := (width + 1<<blockBits - 1) >> blockBits
+	blocksY := (height + 1<<blockBits - 1) >> blockBits
+	modes := make([]uint32, blocksX*blocksY)
+	for i := range modes {
+		modes[i] = 7 << 8
+	}
+	writeEntropyImage(bw, modes, false)
+
+	residuals := make([]uint32, len(argb))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			var prediction uint32
+			switch {
+			case x == 0 && y == 0:
+				prediction = 0xff000000
+			case y == 0:
+				prediction = argb[i-1]
+			case x == 0:
+				prediction = argb[i-width]
+			default:
+				prediction = average2(argb[i-1], argb[i-width])
+			}
+			residuals[i] = subPixels(argb[i], prediction)
+		}
+	}
+
+	bw.write(0, 1) // no more transforms
+	writeEntropyImage(bw, residuals, true)
+
+	data := bw.bytes()
+
+	chunkSize := 1 + len(data)
+	padding := chunkSize & 1
+
+	out := bufio.NewWriter(w)
+	header := make([]byte, 21)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(4+8+chunkSize+padding))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(chunkSize))
+	header[20] = 0x2f
+	out.Write(header)
+	out.Write(data)
+	if padding == 1 {
+		out.WriteByte(0)
+	}
+	return out.Flush()
+}
+
+// writeEntropyImage writes pixels as literals with one Huffman code group
+// and no color cache.
+func writeEntropyImage(bw *bitWriter, pixels []uint32, topLevel bool) {
+	bw.write(0, 1) // no color cache
+	if topLevel {
+		bw.write(0, 1) // no meta Huffman codes
+	}
+
+	counts := [4][]int{make([]int, 256+24), make([]int, 256), make([]int, 256), make([]int, 256)}
+	for _, p := range pixels {
+		counts[0][p>>8&0xff]++
+		counts[1][p>>16&0xff]++
+		counts[2][p&0xff]++
+		counts[3][p>>24]++
+	}
+
+	var codes [4]huffmanCode
+	for i := range counts {
+		codes[i] = writeHuffmanCode(bw, counts[i], 15)
+	}
+	// The distance code is never used.
+	writeHuffmanCode(bw, make([]int, 40), 15)
+
+	for _, p := range pixels {
+		codes[0].write(bw, int(p>>8&0xff))
+		codes[1].write(bw, int(p>>16&0xff))
+		codes[2].write(bw, int(p&0xff))
+		codes[3].write(bw, int(p>>24))
+	}
+}
+
+// huffmanCode holds the bit reversed canonical code for each symbol, ready
+// for the LSB first bit writer.
+type huffmanCode struct {
+	codes   []uint32
+	lengths []int
+}
+
+func (h huffmanCode) write(bw *bitWriter, symbol int) {
+	if h.lengths[symbol] > 0 {
+		bw.write(uint64(h.codes[symbol]), uint(h.lengths[symbol]))
+	}
+}
+
+// codeLengthOrder is the order the code length code lengths are stored in.
+var codeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// writeHuffmanCode builds a code for the symbol counts, writes its
+// description and returns it.
+func writeHuffmanCode(bw *bitWriter, counts []int, maxLength int) huffmanCode {
+	var used []int
+	for symbol, count := range counts {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+
+	// One or two small symbols fit the simple form. With one symbol it
+	// takes no bits at all.
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		code := huffmanCode{codes: make([]uint32, len(counts)), lengths: make([]int, len(counts))}
+		if len(used) == 0 {
+			used = []int{0}
+		}
+
+		bw.write(1, 1)
+		bw.write(uint64(len(used)-1), 1)
+		if used[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint64(used[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint64(used[0]), 8)
+		}
+		if len(used) == 2 {
+			bw.write(uint64(used[1]), 8)
+			code.lengths[used[0]], code.lengths[used[1]] = 1, 1
+			code.codes[used[1]] = 1
+		}
+		return code
+	}
+
+	lengths := huffmanLengths(counts, maxLength)
+	bw.write(0, 1)
+
+	// Code lengths are run length coded: 17 and 18 stand for runs of zeros.
+	var tokens, extras []int
+	for i := 0; i < len(lengths); {
+		if lengths[i] != 0 {
+			tokens, extras = append(tokens, lengths[i]), append(extras, 0)
+			i++
+			continue
+		}
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == 0 && run < 138 {
+			run++
+		}
+		switch {
+		case run >= 11:
+			tokens, extras = append(tokens, 18), append(extras, run-11)
+		case run >= 3:
+			tokens, extras = append(tokens, 17), append(extras, run-3)
+		default:
+			run = 1
+			tokens, extras = append(tokens, 0), append(extras, 0)
+		}
+		i += run
+	}
+
+	tokenCounts := make([]int, 19)
+	for _, token := range tokens {
+		tokenCounts[token]++
+	}
+	tokenLengths := huffmanLengths(tokenCounts, 7)
+	tokenCode := canonicalCode(tokenLengths)
+
+	numCodes := 19
+	for numCodes > 4 && tokenLengths[codeLengthOrder[numCodes-1]] == 0 {
+		numCodes--
+	}
+	bw.write(uint64(numCodes-4), 4)
+	for i := 0; i < numCodes; i++ {
+		bw.write(uint64(tokenLengths[codeLengthOrder[i]]), 3)
+	}
+
+	bw.write(0, 1) // code lengths for the whole alphabet follow
+	for i, token := range tokens {
+		tokenCode.write(bw, token)
+		switch token {
+		case 17:
+			bw.write(uint64(extras[i]), 3)
+		case 18:
+			bw.write(uint64(extras[i]), 7)
+		}
+	}
+
+	return canonicalCode(lengths)
+}
+
+// huffmanLengths returns code lengths for counts that are no longer than
+// maxLength. When the optimal code is too deep, small counts are raised and
+// the code rebuilt, as libwebp does.
+func huffmanLengths(counts []int, maxLength int) []int {
+	type node struct {
+		count       int
+		symbol      int
+		left, right int
+	}
+
+	for minCount := 1; ; minCount *= 2 {
+		var nodes []node
+		var queue []int
+		for symbol, count := range counts {
+			if count > 0 {
+				if count < minCount {
+					count = minCount
+				}
+				nodes = append(nodes, node{count: count, symbol: symbol, left: -1, right: -1})
+				queue = append(queue, len(nodes)-1)
+			}
+		}
+
+		lengths := make([]int, len(counts))
+		if len(queue) == 1 {
+			lengths[nodes[0].symbol] = 1
+			return lengths
+		}
+
+		for len(queue) > 1 {
+			sort.SliceStable(queue, func(i, j int) bool {
+				return nodes[queue[i]].count < nodes[queue[j]].count
+			})
+			a, b := queue[0], queue[1]
+			nodes = append(nodes, node{count: nodes[a].count + nodes[b].count, symbol: -1, left: a, right: b})
+			queue = append(queue[2:], len(nodes)-1)
+		}
+
+		tooDeep := false
+		var walk func(n int, depth int)
+		walk = func(n int, depth int) {
+			if nodes[n].left < 0 {
+				lengths[nodes[n].symbol] = depth
+				if depth > maxLength {
+					tooDeep = true
+				}
+				return
+			}
+			walk(nodes[n].left, depth+1)
+			walk(nodes[n].right, depth+1)
+		}
+		walk(queue[0], 0)
+
+		if !tooDeep {
+			return lengths
+		}
+	}
+}
+
+// canonicalCode assigns canonical codes to the code lengths.
+func canonicalCode(lengths []int) huffmanCode {
+	code := huffmanCode{codes: make([]uint32, len(lengths)), lengths: lengths}
+
+	var lengthCounts [16]int
+	used := 0
+	for _, length := range lengths {
+		if length > 0 {
+			lengthCounts[length]++
+			used++
+		}
+	}
+
+	// A single symbol is decoded without reading any bits.
+	if used == 1 {
+		code.lengths = make([]int, len(lengths))
+		return code
+	}
+
+	var next [16]uint32
+	var c uint32
+	for length := 1; length < 16; length++ {
+		c = (c + uint32(lengthCounts[length-1])) << 1
+		next[length] = c
+	}
+
+	for symbol, length := range lengths {
+		if length > 0 {
+			code.codes[symbol] = reverseBits(next[length], length)
+			next[length]++
+		}
+	}
+	return code
+}
+
+func reverseBits(v uint32, n int) uint32 {
+	var r uint32
+	for i := 0; i < n; i++ {
+		r = r<<1 | v&1
+		v >>= 1
+	}
+	return r
+}
+
+func average2(a uint32, b uint32) uint32 {
+	return (((a ^ b) & 0xfefefefe) >> 1) + (a & b)
+}
+
+// subPixels subtracts b from a per channel, modulo 256.
+func subPixels(a uint32, b uint32) uint32 {
+	alphaGreen := 0x00ff00ff + (a & 0xff00ff00) - (b & 0xff00ff00)
+	redBlue := 0xff00ff00 + (a & 0x00ff00ff) - (b & 0x00ff00ff)
+	return alphaGreen&0xff00ff00 | redBlue&0x00ff00ff
+}
+
+// bitWriter packs values least significant bit first, as VP8L expects.
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nBits uint
+}
+
+func (b *bitWriter) write(v uint64, n uint) {
+	b.acc |= v << b.nBits
+	b.nBits += n
+	for b.nBits >= 8 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc >>= 8
+		b.nBits -= 8
+	}
+}
+
+func (b *bitWriter) bytes() []byte {
+	if b.nBits > 0 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc, b.nBits = 0, 0
+	}
+	return b.buf
+}
@@ -0,0 +1,98 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestEncodeWebPRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	tests := []struct {
+		name  string
+		image image.Image
+	}{
+		{"single pixel", filled(1, 1, func(x, y int) color.NRGBA { return color.NRGBA{10, 20, 30, 255} })},
+		{"solid", filled(64, 48, func(x, y int) color.NRGBA { return color.NRGBA{200, 100, 50, 255} })},
+		{"gradient with odd size", filled(37, 23, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x * 7), uint8(y * 11), uint8(x + y), 255}
+		})},
+		{"alpha", filled(17, 9, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x * 15), 128, uint8(y * 28), uint8(x * y)}
+		})},
+		{"crosses predictor blocks", filled(600, 3, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x), uint8(x >> 2), uint8(y * 80), 255}
+		})},
+		{"noise", filled(32, 32, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(random.Intn(256)), uint8(random.Intn(256)), uint8(random.Intn(256)), uint8(random.Intn(256))}
+		})},
+		{"offset bounds", filled(20, 20, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x * 12), uint8(y * 12), 90, 255}
+		}).SubImage(image.Rect(5, 7, 13, 12))},
+		{"gray source", &image.Gray{Pix: []uint8{0, 64, 128, 255}, Stride: 2, Rect: image.Rect(0, 0, 2, 2)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := EncodeWebP(&buf, test.image); err != nil {
+				t.Fatalf("EncodeWebP: %v", err)
+			}
+
+			decoded, err := webp.Decode(&buf)
+			if err != nil {
+				t.Fatalf("webp.Decode: %v", err)
+			}
+
+			bounds := test.image.Bounds()
+			if decoded.Bounds() != image.Rect(0, 0, bounds.Dx(), bounds.Dy()) {
+				t.Fatalf("decoded bounds %v, want %dx%d", decoded.Bounds(), bounds.Dx(), bounds.Dy())
+			}
+			for y := 0; y < bounds.Dy(); y++ {
+				for x := 0; x < bounds.Dx(); x++ {
+					want := color.NRGBAModel.Convert(test.image.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+					got := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+					if got != want {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeWebPSize(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		wantErr       bool
+	}{
+		{"empty", 0, 0, true},
+		{"no height", 10, 0, true},
+		{"widest", 1 << 14, 1, false},
+		{"too wide", 1<<14 + 1, 1, true},
+		{"too tall", 1, 1<<14 + 1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := EncodeWebP(&bytes.Buffer{}, image.NewNRGBA(image.Rect(0, 0, test.width, test.height)))
+			if (err != nil) != test.wantErr {
+				t.Errorf("EncodeWebP of %dx%d: err = %v, want error %v", test.width, test.height, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func filled(width int, height int, pixel func(x, y int) color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, pixel(x, y))
+		}
+	}
+	return img
+}
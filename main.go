@@ -1,9 +1,18 @@
 package main
 
 import (
+	"context"
 	"imageApi/controllers"
 	_ "imageApi/docs"
+	"imageApi/middlewares"
 	"imageApi/models"
+	token "imageApi/utils"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
@@ -20,30 +29,175 @@ import (
 
 // @host      localhost:8080
 // @BasePath  /
+
+// @securityDefinitions.apikey  BearerAuth
+// @in                          header
+// @name                        Authorization
+
+// @securityDefinitions.apikey  ApiKeyAuth
+// @in                          header
+// @name                        X-API-Key
 func main() {
 
-	r := setupRouter()
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	r, images := setupRouter()
+
+	srv := &http.Server{Addr: listenAddr(), Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("server error:", err)
+		}
+	}()
+
+	// On SIGINT or SIGTERM stop taking requests, let the running ones and
+	// the running jobs finish and then stop the metadata extractor. Jobs
+	// still running when time is up are left to be requeued on the next
+	// start, and the extractor to exit with the server.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Println("shutdown error:", err)
+	}
+
+	if err := images.StopJobWorkers(ctx); err != nil {
+		log.Println("error stopping job workers:", err)
+		return
+	}
+
+	if err := controllers.CloseMetadataExtractor(); err != nil {
+		log.Println("error closing metadata extractor:", err)
+	}
+}
 
-	r.Run()
+// shutdownTimeout bounds how long running requests and jobs get to finish.
+const shutdownTimeout = 30 * time.Second
 
+// listenAddr returns the address to serve on, port PORT or 8080, as gin's
+// Run does.
+func listenAddr() string {
+	if port := os.Getenv("PORT"); port != "" {
+		return ":" + port
+	}
+	return ":8080"
 }
 
-func setupRouter() *gin.Engine {
+func setupRouter() (*gin.Engine, *controllers.ImageController) {
 	r := gin.Default()
 
 	r.GET("/docs/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
 	models.ConnectDatabase()
 
-	r.GET("/images", controllers.FindImages)
+	// Apply pending migrations on start unless AUTO_MIGRATE=false, in which
+	// case they are run with "imageApi migrate up".
+	if os.Getenv("AUTO_MIGRATE") != "false" {
+		if _, err := models.MigrateUp(); err != nil {
+			log.Fatal("migration error:", err)
+		}
+	}
+
+	if err := token.LoadKeys(); err != nil {
+		log.Fatal("token key error:", err)
+	}
+
+	if _, err := controllers.LoadMetadataExtractor(); err != nil {
+		log.Fatal("metadata extractor error:", err)
+	}
+
+	images := controllers.NewImageController(newImageStore())
+
+	images.StartJobWorkers()
+
+	// Changes to images need a bearer token from /auth/login, or an API key
+	// with the images:write scope, and at least the editor role. Reads work
+	// without either but only see public images. Accounts, API keys and
+	// admin routes need a bearer token. Jobs are only shown to whoever
+	// queued them.
+	auth := middlewares.JwtAuthMiddleware()
+	keyAuth := middlewares.AuthMiddleware()
+	optionalAuth := middlewares.OptionalAuthMiddleware()
+	read := middlewares.RequireScope(models.ScopeImagesRead)
+	write := middlewares.RequireScope(models.ScopeImagesWrite)
+	editor := middlewares.RequireRole(models.RoleEditor)
+	admin := middlewares.RequireRole(models.RoleAdmin)
+
+	r.GET("/.well-known/jwks.json", controllers.JWKS)
+
+	r.POST("/auth/register", controllers.Register)
+
+	r.POST("/auth/login", controllers.Login)
+
+	r.POST("/auth/refresh", controllers.RefreshToken)
+
+	r.POST("/auth/logout", auth, controllers.Logout)
+
+	r.GET("/auth/me", auth, controllers.CurrentUser)
+
+	r.POST("/auth/api-keys", auth, controllers.CreateAPIKey)
+
+	r.GET("/auth/api-keys", auth, controllers.ListAPIKeys)
+
+	r.DELETE("/auth/api-keys/:key_id", auth, controllers.RevokeAPIKey)
+
+	r.GET("/images", optionalAuth, read, images.FindImages)
+
+	r.GET("/images/near", optionalAuth, read, images.NearImages)
 
-	r.GET("/images/:image_id", controllers.FindImage)
+	r.GET("/images/within", optionalAuth, read, images.WithinImages)
 
-	r.POST("/images", controllers.CreateImage)
+	r.GET("/images/duplicates", optionalAuth, read, images.FindDuplicates)
 
-	r.DELETE("/images/:image_id", controllers.DeleteImage)
+	r.GET("/images/near-duplicates", optionalAuth, read, images.NearDuplicates)
 
-	r.PATCH("/images/:image_id", controllers.UpdateImage)
+	r.GET("/images/:image_id", optionalAuth, read, images.FindImage)
+
+	r.GET("/images/:image_id/thumbnail", optionalAuth, read, images.FindThumbnail)
+
+	r.GET("/images/:image_id/render", optionalAuth, read, images.RenderImage)
+
+	r.GET("/images/:image_id/similar", optionalAuth, read, images.SimilarImages)
+
+	r.GET("/images/:image_id/metadata", optionalAuth, read, images.FindImageMetadata)
+
+	r.GET("/images/:image_id/file", optionalAuth, read, images.DownloadImage)
+
+	r.HEAD("/images/:image_id/file", optionalAuth, read, images.DownloadImage)
+
+	r.POST("/images", keyAuth, write, editor, images.CreateImage)
+
+	r.POST("/images/upload", keyAuth, write, editor, images.UploadImage)
+
+	r.POST("/images/import", keyAuth, write, editor, images.ImportImages)
+
+	r.DELETE("/images/:image_id", keyAuth, write, editor, images.DeleteImage)
+
+	r.PATCH("/images/:image_id", keyAuth, write, editor, images.UpdateImage)
+
+	r.GET("/jobs/:job_id", keyAuth, controllers.FindJob)
+
+	r.GET("/admin/users", auth, admin, controllers.ListUsers)
+
+	r.PATCH("/admin/users/:user_id", auth, admin, controllers.UpdateUser)
+
+	r.POST("/admin/images/delete", auth, admin, images.BulkDeleteImages)
+
+	r.POST("/admin/images/reindex", auth, admin, controllers.ReindexImages)
+
+	return r, images
+}
 
-	return r
+// newImageStore picks the image store from IMAGE_STORE. Images live in the
+// database unless it is set to memory.
+func newImageStore() models.ImageStore {
+	if os.Getenv("IMAGE_STORE") == "memory" {
+		return models.NewMemoryImageStore()
+	}
+	return models.NewGormImageStore(models.DB)
 }
@@ -1,6 +1,7 @@
 package middlewares
 
 import (
+	"imageApi/models"
 	"net/http"
 
 	token "imageApi/utils"
@@ -8,10 +9,12 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// JwtAuthMiddleware rejects requests without a valid token of an enabled
+// user and stores the token's user ID and role in the context under
+// "user_id" and "role".
 func JwtAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		err := token.TokenValid(c)
-		if err != nil {
+		if !authenticate(c) {
 			c.String(http.StatusUnauthorized, "Unauthorized")
 			c.Abort()
 			return
@@ -19,3 +22,105 @@ func JwtAuthMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// AuthMiddleware is JwtAuthMiddleware that also accepts an API key in the
+// X-API-Key header. For a key it also stores the key's scopes under
+// "scopes", which RequireScope checks.
+func AuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authenticateKeyOrToken(c) {
+			c.String(http.StatusUnauthorized, "Unauthorized")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// OptionalAuthMiddleware lets anonymous requests through but still rejects
+// invalid tokens and API keys, so routes that anyone may use can tell who is
+// calling.
+func OptionalAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if (token.ExtractToken(c) != "" || token.ExtractAPIKey(c) != "") && !authenticateKeyOrToken(c) {
+			c.String(http.StatusUnauthorized, "Unauthorized")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireRole rejects requests from users whose role is below role. It goes
+// after JwtAuthMiddleware or AuthMiddleware on a route.
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !models.HasRole(c.GetString("role"), role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This needs the " + role + " role"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireScope rejects requests made with an API key that lacks scope.
+// Bearer tokens are not limited by scopes.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if scopes, ok := c.Get("scopes"); ok && !containsString(scopes.([]string), scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This needs an API key with the " + scope + " scope"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func authenticateKeyOrToken(c *gin.Context) bool {
+	apiKey := token.ExtractAPIKey(c)
+	if apiKey == "" {
+		return authenticate(c)
+	}
+
+	// An API key acts with the role its user has now.
+	key, user, err := models.UseAPIKey(token.HashAPIKey(apiKey))
+	if err != nil {
+		return false
+	}
+
+	c.Set("user_id", user.UserID)
+	c.Set("role", user.UserRole)
+	c.Set("scopes", key.ScopeList())
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func authenticate(c *gin.Context) bool {
+	if err := token.TokenValid(c); err != nil {
+		return false
+	}
+	userID, err := token.ExtractTokenID(c)
+	if err != nil || userID == 0 {
+		return false
+	}
+
+	// Disabling a user or changing their role takes effect on tokens that
+	// were already issued.
+	user, err := models.GetUserByID(userID)
+	if err != nil || user.UserDisabled {
+		return false
+	}
+
+	c.Set("user_id", userID)
+	c.Set("role", user.UserRole)
+	return true
+}
@@ -0,0 +1,204 @@
+package middlewares
+
+import (
+	"imageApi/models"
+	token "imageApi/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+func useTestDB(t *testing.T) {
+	t.Helper()
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	db.DB().SetMaxOpenConns(1)
+	db.LogMode(false)
+
+	previous := models.DB
+	models.DB = db
+	t.Cleanup(func() {
+		models.DB = previous
+		db.Close()
+	})
+
+	if _, err := models.MigrateUp(); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	t.Setenv("API_SECRET", "test secret")
+}
+
+// newRouter serves GET /read and POST /write behind AuthMiddleware, as the
+// image routes are, and GET /admin behind JwtAuthMiddleware and the admin
+// role. Each responds with the user ID and role the middleware set.
+func newRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	whoami := func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"user_id": c.GetUint("user_id"), "role": c.GetString("role")})
+	}
+	keyAuth := AuthMiddleware()
+	r.GET("/read", keyAuth, RequireScope(models.ScopeImagesRead), whoami)
+	r.POST("/write", keyAuth, RequireScope(models.ScopeImagesWrite), RequireRole(models.RoleEditor), whoami)
+	r.GET("/admin", JwtAuthMiddleware(), RequireRole(models.RoleAdmin), whoami)
+	r.GET("/optional", OptionalAuthMiddleware(), whoami)
+	return r
+}
+
+func serve(r http.Handler, method string, path string, header string, value string) int {
+	req := httptest.NewRequest(method, path, nil)
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func createUser(t *testing.T, username string, role string) models.User {
+	t.Helper()
+
+	user, err := models.CreateUser(username, "password1", role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func createAPIKey(t *testing.T, user models.User, scopes ...string) string {
+	t.Helper()
+
+	key, hash, err := token.GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.CreateAPIKey(user.UserID, "test", key[:8], hash, scopes); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func bearer(t *testing.T, user models.User, role string) string {
+	t.Helper()
+
+	tokenString, err := token.GenerateToken(user.UserID, role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + tokenString
+}
+
+func disable(t *testing.T, user models.User) {
+	t.Helper()
+
+	yes := true
+	if _, err := models.UpdateUser(user.UserID, "", &yes); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	useTestDB(t)
+	r := newRouter()
+
+	editor := createUser(t, "editor", models.RoleEditor)
+	readWrite := createAPIKey(t, editor, models.ScopeImagesRead, models.ScopeImagesWrite)
+	readOnly := createAPIKey(t, editor, models.ScopeImagesRead)
+
+	viewer := createUser(t, "viewer", models.RoleViewer)
+	viewerKey := createAPIKey(t, viewer, models.ScopeImagesRead, models.ScopeImagesWrite)
+
+	disabled := createUser(t, "disabled", models.RoleEditor)
+	disabledKey := createAPIKey(t, disabled, models.ScopeImagesRead, models.ScopeImagesWrite)
+	disable(t, disabled)
+
+	revokedKey := createAPIKey(t, editor, models.ScopeImagesRead)
+	keys, _ := models.ListAPIKeys(editor.UserID)
+	if _, err := models.RevokeAPIKey(editor.UserID, keys[len(keys)-1].APIKeyID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		status int
+	}{
+		{"read with read scope", "GET", "/read", readOnly, http.StatusOK},
+		{"write with write scope", "POST", "/write", readWrite, http.StatusOK},
+		{"write without write scope", "POST", "/write", readOnly, http.StatusForbidden},
+		{"write with a viewer's key", "POST", "/write", viewerKey, http.StatusForbidden},
+		{"key of a disabled user", "GET", "/read", disabledKey, http.StatusUnauthorized},
+		{"revoked key", "GET", "/read", revokedKey, http.StatusUnauthorized},
+		{"unknown key", "GET", "/read", "iak_made-up", http.StatusUnauthorized},
+		{"key hash as the key", "GET", "/read", token.HashAPIKey(readOnly), http.StatusUnauthorized},
+		{"key on a bearer only route", "GET", "/admin", readWrite, http.StatusUnauthorized},
+		{"bad key on an optional route", "GET", "/optional", "iak_made-up", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if status := serve(r, test.method, test.path, "X-API-Key", test.key); status != test.status {
+				t.Errorf("status %d, want %d", status, test.status)
+			}
+		})
+	}
+
+	// Only the key's hash is stored, so it is looked up by hash.
+	if _, user, err := models.UseAPIKey(token.HashAPIKey(readOnly)); err != nil || user.UserID != editor.UserID {
+		t.Errorf("UseAPIKey = %v, %v", user.UserID, err)
+	}
+}
+
+// Bearer tokens are authorized with the role the user has now rather than
+// the one in the token, and are not limited by API key scopes.
+func TestBearerTokens(t *testing.T) {
+	useTestDB(t)
+	r := newRouter()
+
+	admin := createUser(t, "admin", models.RoleAdmin)
+	demoted := createUser(t, "demoted", models.RoleAdmin)
+	demotedToken := bearer(t, demoted, models.RoleAdmin)
+	if _, err := models.UpdateUser(demoted.UserID, models.RoleViewer, nil); err != nil {
+		t.Fatal(err)
+	}
+	viewer := createUser(t, "viewer", models.RoleViewer)
+	disabled := createUser(t, "disabled", models.RoleAdmin)
+	disabledToken := bearer(t, disabled, models.RoleAdmin)
+	disable(t, disabled)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		auth   string
+		status int
+	}{
+		{"admin", "GET", "/admin", bearer(t, admin, models.RoleAdmin), http.StatusOK},
+		{"admin writes without scopes", "POST", "/write", bearer(t, admin, models.RoleAdmin), http.StatusOK},
+		{"demoted admin", "GET", "/admin", demotedToken, http.StatusForbidden},
+		{"viewer claiming admin", "GET", "/admin", bearer(t, viewer, models.RoleAdmin), http.StatusForbidden},
+		{"viewer writing", "POST", "/write", bearer(t, viewer, models.RoleViewer), http.StatusForbidden},
+		{"disabled user", "GET", "/read", disabledToken, http.StatusUnauthorized},
+		{"no token", "GET", "/read", "", http.StatusUnauthorized},
+		{"bad token", "GET", "/read", "Bearer not.a.token", http.StatusUnauthorized},
+		{"anonymous on an optional route", "GET", "/optional", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := ""
+			if test.auth != "" {
+				header = "Authorization"
+			}
+			if status := serve(r, test.method, test.path, header, test.auth); status != test.status {
+				t.Errorf("status %d, want %d", status, test.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// API key scopes.
+const (
+	ScopeImagesRead  = "images:read"
+	ScopeImagesWrite = "images:write"
+)
+
+// Last-used times are only written when they are at least this old, so a
+// busy key does not cost a database write per request.
+const apiKeyTouchInterval = time.Minute
+
+var (
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	ErrInvalidAPIKey  = errors.New("API key is invalid or revoked")
+)
+
+// APIKey lets scripts act for a user without logging in. Only the key's hash
+// is stored; KeyPrefix is kept so users can tell their keys apart.
+type APIKey struct {
+	APIKeyID   uint   `gorm:"primary_key"`
+	UserID     uint   `gorm:"index"`
+	Name       string `gorm:"not null"`
+	KeyPrefix  string
+	KeyHash    string `gorm:"unique;not null" json:"-"`
+	Scopes     string
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
+
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+func ValidScope(scope string) bool {
+	return scope == ScopeImagesRead || scope == ScopeImagesWrite
+}
+
+// ScopeList splits the stored scopes.
+func (key APIKey) ScopeList() []string {
+	if key.Scopes == "" {
+		return []string{}
+	}
+	return strings.Split(key.Scopes, ",")
+}
+
+func CreateAPIKey(userID uint, name string, prefix string, keyHash string, scopes []string) (APIKey, error) {
+	key := APIKey{
+		UserID:    userID,
+		Name:      name,
+		KeyPrefix: prefix,
+		KeyHash:   keyHash,
+		Scopes:    strings.Join(scopes, ",")}
+	err := DB.Create(&key).Error
+	return key, err
+}
+
+// ListAPIKeys returns the keys of a user, revoked ones included.
+func ListAPIKeys(userID uint) ([]APIKey, error) {
+	var keys []APIKey
+	err := DB.Where("user_id = ?", userID).Order("api_key_id").Find(&keys).Error
+	return keys, err
+}
+
+// RevokeAPIKey revokes one of a user's keys.
+func RevokeAPIKey(userID uint, id uint) (APIKey, error) {
+	var key APIKey
+	err := DB.Where("api_key_id = ? AND user_id = ?", id, userID).First(&key).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return key, ErrAPIKeyNotFound
+	}
+	if err != nil || key.RevokedAt != nil {
+		return key, err
+	}
+
+	now := time.Now()
+	if err := DB.Model(&key).Update("revoked_at", now).Error; err != nil {
+		return key, err
+	}
+	key.RevokedAt = &now
+	return key, nil
+}
+
+// UseAPIKey looks up an unrevoked key of an enabled user by its hash and
+// records that it was used.
+func UseAPIKey(keyHash string) (APIKey, User, error) {
+	var key APIKey
+	err := DB.Where("key_hash = ? AND revoked_at IS NULL", keyHash).First(&key).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return key, User{}, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return key, User{}, err
+	}
+
+	user, err := GetUserByID(key.UserID)
+	if err == ErrUserNotFound || err == nil && user.UserDisabled {
+		return key, user, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return key, user, err
+	}
+
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		if err := DB.Model(&key).Update("last_used_at", now).Error; err != nil {
+			return key, user, err
+		}
+	}
+	return key, user, nil
+}
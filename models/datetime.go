@@ -0,0 +1,53 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// exifDateTimeLayouts are the EXIF date times exiftool prints. XMP and
+// QuickTime dates may carry a UTC offset, and time.Parse accepts fractional
+// seconds after either.
+var exifDateTimeLayouts = []string{
+	"2006:01:02 15:04:05Z07:00",
+	"2006:01:02 15:04:05",
+}
+
+// ParseExifDateTime reads an EXIF date time and reports whether it has a UTC
+// offset. Zeroed dates are not valid.
+func ParseExifDateTime(dateTime string) (t time.Time, hasZone bool, ok bool) {
+	for i, layout := range exifDateTimeLayouts {
+		if t, err := time.Parse(layout, dateTime); err == nil {
+			return t, i == 0, true
+		}
+	}
+	return time.Time{}, false, false
+}
+
+// ParseImageDateTime reads the date time given for an image, which may be an
+// EXIF date time, an RFC 3339 time or a plain date and time or date. Only
+// RFC 3339 times and EXIF date times with an offset have a known time zone;
+// the others are the camera's local time as if it were UTC.
+func ParseImageDateTime(dateTime string) (t time.Time, hasZone bool, err error) {
+	if t, hasZone, ok := ParseExifDateTime(dateTime); ok {
+		return t, hasZone, nil
+	}
+	if t, err := time.Parse(time.RFC3339, dateTime); err == nil {
+		return t, true, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, dateTime); err == nil {
+			return t, false, nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("cannot parse date %q", dateTime)
+}
+
+// CaptureTimeZone is the ImageTimeZone of the capture time t: its UTC offset,
+// such as "+02:00", when hasZone, and otherwise TimeZoneLocal.
+func CaptureTimeZone(t time.Time, hasZone bool) string {
+	if hasZone {
+		return t.Format("-07:00")
+	}
+	return TimeZoneLocal
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// GeoBounds is a latitude/longitude box in decimal degrees. MinLon may be
+// greater than MaxLon for a box that crosses the antimeridian.
+type GeoBounds struct {
+	MinLat float64
+	MinLon float64
+	MaxLat float64
+	MaxLon float64
+}
+
+// Contains reports whether the point lies inside the box.
+func (b GeoBounds) Contains(lat float64, lon float64) bool {
+	if lat < b.MinLat || lat > b.MaxLat {
+		return false
+	}
+	if b.MinLon <= b.MaxLon {
+		return lon >= b.MinLon && lon <= b.MaxLon
+	}
+	return lon >= b.MinLon || lon <= b.MaxLon
+}
+
+// ValidCoordinates reports whether lat and lon are within range.
+func ValidCoordinates(lat float64, lon float64) bool {
+	return lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180
+}
+
+var coordinateNumbers = regexp.MustCompile(`[-+]?\d+(?:\.\d+)?`)
+
+// ParseCoordinate reads a latitude or longitude in decimal degrees, such as
+// "-122.4194", or in the degrees, minutes and seconds form exiftool prints,
+// such as `37 deg 46' 29.64" N`. A trailing S or W makes the result negative.
+func ParseCoordinate(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("empty coordinate")
+	}
+
+	numbers := coordinateNumbers.FindAllString(value, -1)
+	if len(numbers) == 0 || len(numbers) > 3 {
+		return 0, fmt.Errorf("cannot parse coordinate %q", value)
+	}
+
+	var degrees float64
+	for i, number := range numbers {
+		n, err := strconv.ParseFloat(number, 64)
+		if err != nil {
+			return 0, fmt.Errorf("cannot parse coordinate %q", value)
+		}
+		degrees += math.Abs(n) / math.Pow(60, float64(i))
+	}
+
+	upper := strings.ToUpper(value)
+	if strings.HasPrefix(numbers[0], "-") || strings.HasSuffix(upper, "S") || strings.HasSuffix(upper, "W") {
+		degrees = -degrees
+	}
+	return degrees, nil
+}
+
+// earthRadiusKm is the mean radius of the Earth.
+const earthRadiusKm = 6371.0
+
+// DistanceKm returns the great circle distance between two points using the
+// haversine formula.
+func DistanceKm(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	toRad := math.Pi / 180
+	dLat := (lat2 - lat1) * toRad
+	dLon := (lon2 - lon1) * toRad
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRad)*math.Cos(lat2*toRad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// RadiusBounds returns a box that contains every point within radiusKm of
+// the center, for narrowing a radius search before measuring distances.
+func RadiusBounds(lat float64, lon float64, radiusKm float64) GeoBounds {
+	dLat := radiusKm / earthRadiusKm * 180 / math.Pi
+
+	bounds := GeoBounds{
+		MinLat: math.Max(-90, lat-dLat),
+		MaxLat: math.Min(90, lat+dLat),
+		MinLon: -180,
+		MaxLon: 180}
+
+	// Near the poles every longitude can be within the radius.
+	if bounds.MinLat == -90 || bounds.MaxLat == 90 {
+		return bounds
+	}
+
+	ratio := math.Sin(radiusKm/earthRadiusKm) / math.Cos(lat*math.Pi/180)
+	if radiusKm/earthRadiusKm >= math.Pi/2 || ratio >= 1 {
+		return bounds
+	}
+	dLon := math.Asin(ratio) * 180 / math.Pi
+
+	bounds.MinLon = normalizeLon(lon - dLon)
+	bounds.MaxLon = normalizeLon(lon + dLon)
+	return bounds
+}
+
+func normalizeLon(lon float64) float64 {
+	for lon < -180 {
+		lon += 360
+	}
+	for lon > 180 {
+		lon -= 360
+	}
+	return lon
+}
@@ -1,8 +1,18 @@
 package models
 
+import "time"
+
+// Image visibility. Private images are only seen by their owner, shared
+// images by every signed in user and public images by everyone.
+const (
+	VisibilityPrivate = "private"
+	VisibilityShared  = "shared"
+	VisibilityPublic  = "public"
+)
+
 type Image struct {
-	ImageID          int    `gorm:"primaryKey"`
-	ImageFileName    string `gorm:"unique"`
+	ImageID          int    `gorm:"primary_key"`
+	ImageFileName    string `gorm:"index"`
 	ImageDateTime    string
 	ImageYear        int
 	ImageMonth       int
@@ -16,4 +26,79 @@ type Image struct {
 	ImageType        string
 	ImageMegaPixels  float64
 	ImageFileSize    string
+	ImageLatitude    *float64 `gorm:"index"`
+	ImageLongitude   *float64 `gorm:"index"`
+	ImageOwnerID     uint     `gorm:"index"`
+	ImageVisibility  string   `gorm:"index"`
+	ImageHash        string   `gorm:"index"`
+	// ImagePerceptualHash is the hex encoded 64 bit difference hash, empty
+	// when the file could not be decoded.
+	ImagePerceptualHash string
+	// Camera settings read from the file's EXIF data. Zero values are
+	// unknown. FocalLength is in millimetres, Aperture is the f-number and
+	// ShutterSpeed is the exposure time in seconds. Flash is whether the
+	// flash fired and Orientation the EXIF orientation from 1 to 8.
+	ImageCameraMake   string  `gorm:"index"`
+	ImageCameraModel  string  `gorm:"index"`
+	ImageLensModel    string  `gorm:"index"`
+	ImageFocalLength  float64 `gorm:"index"`
+	ImageAperture     float64 `gorm:"index"`
+	ImageShutterSpeed float64 `gorm:"index"`
+	ImageISO          int     `gorm:"index"`
+	ImageFlash        *bool   `gorm:"index"`
+	ImageWhiteBalance string  `gorm:"index"`
+	ImageOrientation  int
+	ImageSoftware     string
+	// ImageTakenAt is when the image was taken, in UTC. ImageTimeZone is the
+	// UTC offset of the camera's clock, such as "+02:00", or TimeZoneLocal
+	// when it is unknown and ImageTakenAt holds the camera's local time as
+	// if it were UTC. ImageDateTime and the date parts are local time.
+	ImageTakenAt       *time.Time `gorm:"index"`
+	ImageTakenAtSource string
+	ImageTimeZone      string
+	// ImageMetadata is every tag read from the file. It is served on its
+	// own at /images/{image_id}/metadata rather than with the image.
+	ImageMetadata Metadata `json:"-"`
+}
+
+// Where ImageTakenAt was read from, best first: the EXIF DateTimeOriginal and
+// CreateDate tags, the GPS date and time, the date time given when the image
+// was created or updated, a date in the file name, and the file's
+// modification time. Images stored before capture times were parsed have
+// their ImageDateTime as source.
+const (
+	TakenAtDateTimeOriginal = "DateTimeOriginal"
+	TakenAtCreateDate       = "CreateDate"
+	TakenAtGPSDateTime      = "GPSDateTime"
+	TakenAtImageDateTime    = "ImageDateTime"
+	TakenAtFileName         = "FileName"
+	TakenAtFileModifyDate   = "FileModifyDate"
+)
+
+// TimeZoneLocal is the ImageTimeZone of capture times whose UTC offset is
+// unknown.
+const TimeZoneLocal = "local"
+
+func ValidVisibility(visibility string) bool {
+	return visibility == VisibilityPrivate || visibility == VisibilityShared || visibility == VisibilityPublic
+}
+
+// VisibleTo reports whether the user may see the image. A zero userID is an
+// anonymous caller.
+func (image Image) VisibleTo(userID uint) bool {
+	switch image.ImageVisibility {
+	case VisibilityPublic:
+		return true
+	case VisibilityShared:
+		return userID != 0
+	}
+	return userID != 0 && image.ImageOwnerID == userID
+}
+
+// EditableBy reports whether the user may change or delete the image. Images
+// without an owner, such as those indexed from the command line or stored
+// before owners were kept, may only be changed by admins, who are checked
+// separately.
+func (image Image) EditableBy(userID uint) bool {
+	return userID != 0 && image.ImageOwnerID == userID
 }
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Job states. A job is queued until a worker claims it, and ends as either
+// succeeded or failed. A running job's worker renews JobHeartbeatAt, and a
+// running job whose heartbeat has stopped is queued again.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+type Job struct {
+	JobID          int `gorm:"primary_key"`
+	JobType        string
+	JobStatus      string `gorm:"index"`
+	JobPayload     string `gorm:"type:text"`
+	JobError       string `gorm:"type:text"`
+	JobErrorCode   string
+	JobImageID     int
+	JobOwnerID     uint `gorm:"index"`
+	JobHeartbeatAt *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// Metadata is every tag the metadata extractor read from an image file,
+// stored as a JSON object. A nil Metadata is stored as NULL.
+type Metadata map[string]interface{}
+
+func (m Metadata) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (m *Metadata) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Metadata", src)
+	}
+	return json.Unmarshal(data, m)
+}
+
+// Metadata keys are exiftool tag names such as "Artist" or "XMP-dc:Creator".
+// Nothing else is accepted, so a key never needs escaping in a JSON path.
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_:-]+$`)
+
+// ValidMetadataKey reports whether key can be used in ImageFilter.Metadata.
+func ValidMetadataKey(key string) bool {
+	return metadataKeyPattern.MatchString(key)
+}
+
+// metadataCondition returns the condition, for the JSON functions of
+// dialect, and its parameters that match images whose metadata tag key has
+// the value. Tags holding a JSON number are compared as numbers, since the
+// databases print numbers in ways that differ from each other and from
+// metadataText, and other tags are compared as text.
+func metadataCondition(dialect string, key string, value string) (string, []interface{}) {
+	var isNumber, number, text string
+	switch dialect {
+	case "postgres":
+		isNumber = "jsonb_typeof(image_metadata -> CAST(? AS text)) = 'number'"
+		number = "CAST(image_metadata ->> CAST(? AS text) AS double precision) = ?"
+		text = "image_metadata ->> CAST(? AS text) = ?"
+	case "mysql":
+		isNumber = "JSON_TYPE(JSON_EXTRACT(image_metadata, CONCAT('$.\"', ?, '\"'))) IN ('INTEGER', 'UNSIGNED INTEGER', 'DOUBLE', 'DECIMAL')"
+		number = "JSON_EXTRACT(image_metadata, CONCAT('$.\"', ?, '\"')) = ?"
+		text = "JSON_UNQUOTE(JSON_EXTRACT(image_metadata, CONCAT('$.\"', ?, '\"'))) = ?"
+	default:
+		isNumber = "json_type(image_metadata, '$.\"' || ? || '\"') IN ('integer', 'real')"
+		number = "json_extract(image_metadata, '$.\"' || ? || '\"') = ?"
+		text = "CAST(json_extract(image_metadata, '$.\"' || ? || '\"') AS TEXT) = ?"
+	}
+
+	// Only a number can equal a number tag.
+	n, ok := metadataNumber(value)
+	if !ok {
+		return "NOT (" + isNumber + ") AND " + text, []interface{}{key, key, value}
+	}
+	return "(" + isNumber + " AND " + number + ") OR (NOT (" + isNumber + ") AND " + text + ")",
+		[]interface{}{key, key, n, key, key, value}
+}
+
+// metadataMatches reports whether a tag value matches a filter value the
+// way metadataCondition does.
+func metadataMatches(tag interface{}, value string) bool {
+	if f, isNumber := tag.(float64); isNumber {
+		n, ok := metadataNumber(value)
+		return ok && f == n
+	}
+	return metadataText(tag) == value
+}
+
+// metadataNumber parses a filter value that is a finite number.
+func metadataNumber(value string) (float64, bool) {
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsInf(n, 0) || math.IsNaN(n) {
+		return 0, false
+	}
+	return n, true
+}
+
+// metadataText formats a tag value the way the databases print JSON scalars.
+func metadataText(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
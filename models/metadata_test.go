@@ -0,0 +1,60 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Metadata filters must match the same images on SQLite as in memory,
+// whichever way a number is written.
+func TestMetadataFilterNumbers(t *testing.T) {
+	tags := []Metadata{
+		{"FNumber": 2.8},
+		{"FNumber": 0.30000000000000004},
+		{"FNumber": 0.000001},
+		{"FNumber": 100.0},
+		{"FNumber": "2.8"},
+		{"FNumber": "f/2.8"},
+		{"Artist": "Jane"},
+	}
+
+	tests := []struct {
+		value string
+		want  []int
+	}{
+		{"2.8", []int{1, 5}},
+		{"2.80", []int{1}},
+		{"0.30000000000000004", []int{2}},
+		{"0.3", nil},
+		{"0.000001", []int{3}},
+		{"1e-6", []int{3}},
+		{"100", []int{4}},
+		{"1e2", []int{4}},
+		{"f/2.8", []int{6}},
+		{"NaN", nil},
+	}
+
+	stores := map[string]func(t *testing.T) ImageStore{
+		"memory": func(t *testing.T) ImageStore { return NewMemoryImageStore() },
+		"gorm":   newSQLiteStore,
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			for _, metadata := range tags {
+				image := Image{ImageMetadata: metadata}
+				if err := store.Create(&image); err != nil {
+					t.Fatalf("Create: %v", err)
+				}
+			}
+
+			for _, test := range tests {
+				query := ImageQuery{Filter: ImageFilter{Metadata: map[string]string{"FNumber": test.value}}}
+				got := searchIDs(t, store, query)
+				if fmt.Sprint(got) != fmt.Sprint(append([]int{}, test.want...)) {
+					t.Errorf("FNumber=%s matched %v, want %v", test.value, got, test.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,847 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Migration is one numbered schema change. Up applies it and Down reverts
+// it. Migrations run in Version order, each in its own transaction. That
+// makes them all or nothing on PostgreSQL and SQLite, but MySQL commits
+// every schema change as it runs, so a failed migration can be left half
+// done there. Every step skips what is already done, so such a migration
+// can simply be run again once the cause is fixed.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration records an applied migration in the schema_migrations
+// table.
+type SchemaMigration struct {
+	Version   int `gorm:"primary_key;auto_increment:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// MigrationStatus describes a known migration and whether it is applied.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// The migrations below define their own copies of the tables they touch so
+// that later changes to the models do not rewrite history.
+
+type imageV1 struct {
+	ImageID          int    `gorm:"primary_key"`
+	ImageFileName    string `gorm:"unique"`
+	ImageDateTime    string
+	ImageYear        int
+	ImageMonth       int
+	ImageDay         int
+	ImageDirLocation string
+	ImageWidth       int
+	ImageHeight      int
+	ImageLat         string
+	ImageLon         string
+	ImageSize        string
+	ImageType        string
+	ImageMegaPixels  float64
+	ImageFileSize    string
+}
+
+func (imageV1) TableName() string {
+	return "images"
+}
+
+type jobV2 struct {
+	JobID        int `gorm:"primary_key"`
+	JobType      string
+	JobStatus    string `gorm:"index"`
+	JobPayload   string `gorm:"type:text"`
+	JobError     string `gorm:"type:text"`
+	JobErrorCode string
+	JobImageID   int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (jobV2) TableName() string {
+	return "jobs"
+}
+
+type imageV3 struct {
+	ImageLatitude  *float64 `gorm:"index"`
+	ImageLongitude *float64 `gorm:"index"`
+}
+
+func (imageV3) TableName() string {
+	return "images"
+}
+
+type userV4 struct {
+	UserID       uint   `gorm:"primary_key"`
+	Username     string `gorm:"unique;not null"`
+	PasswordHash string `gorm:"not null"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (userV4) TableName() string {
+	return "users"
+}
+
+type imageV5 struct {
+	ImageOwnerID    uint   `gorm:"index"`
+	ImageVisibility string `gorm:"index"`
+}
+
+func (imageV5) TableName() string {
+	return "images"
+}
+
+type userV6 struct {
+	UserRole     string
+	UserDisabled bool
+}
+
+func (userV6) TableName() string {
+	return "users"
+}
+
+type refreshTokenV7 struct {
+	RefreshTokenID uint   `gorm:"primary_key"`
+	UserID         uint   `gorm:"index"`
+	FamilyID       string `gorm:"index"`
+	TokenHash      string `gorm:"unique;not null"`
+	ExpiresAt      time.Time
+	RevokedAt      *time.Time
+	CreatedAt      time.Time
+}
+
+func (refreshTokenV7) TableName() string {
+	return "refresh_tokens"
+}
+
+type revokedTokenV7 struct {
+	JTI       string    `gorm:"primary_key"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+func (revokedTokenV7) TableName() string {
+	return "revoked_tokens"
+}
+
+type apiKeyV8 struct {
+	APIKeyID   uint   `gorm:"primary_key"`
+	UserID     uint   `gorm:"index"`
+	Name       string `gorm:"not null"`
+	KeyPrefix  string
+	KeyHash    string `gorm:"unique;not null"`
+	Scopes     string
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
+
+func (apiKeyV8) TableName() string {
+	return "api_keys"
+}
+
+// imageV5Full and imageV9 are the whole images table before and after
+// version 9, for rebuilding it on SQLite.
+type imageV5Full struct {
+	ImageID          int    `gorm:"primary_key"`
+	ImageFileName    string `gorm:"unique"`
+	ImageDateTime    string
+	ImageYear        int
+	ImageMonth       int
+	ImageDay         int
+	ImageDirLocation string
+	ImageWidth       int
+	ImageHeight      int
+	ImageLat         string
+	ImageLon         string
+	ImageSize        string
+	ImageType        string
+	ImageMegaPixels  float64
+	ImageFileSize    string
+	ImageLatitude    *float64 `gorm:"index"`
+	ImageLongitude   *float64 `gorm:"index"`
+	ImageOwnerID     uint     `gorm:"index"`
+	ImageVisibility  string   `gorm:"index"`
+}
+
+func (imageV5Full) TableName() string {
+	return "images"
+}
+
+type imageV9 struct {
+	ImageID          int    `gorm:"primary_key"`
+	ImageFileName    string `gorm:"index"`
+	ImageDateTime    string
+	ImageYear        int
+	ImageMonth       int
+	ImageDay         int
+	ImageDirLocation string
+	ImageWidth       int
+	ImageHeight      int
+	ImageLat         string
+	ImageLon         string
+	ImageSize        string
+	ImageType        string
+	ImageMegaPixels  float64
+	ImageFileSize    string
+	ImageLatitude    *float64 `gorm:"index"`
+	ImageLongitude   *float64 `gorm:"index"`
+	ImageOwnerID     uint     `gorm:"index"`
+	ImageVisibility  string   `gorm:"index"`
+	ImageHash        string   `gorm:"index"`
+}
+
+func (imageV9) TableName() string {
+	return "images"
+}
+
+type imageV10 struct {
+	ImagePerceptualHash string
+}
+
+func (imageV10) TableName() string {
+	return "images"
+}
+
+type imageV11 struct {
+	ImageCameraMake   string  `gorm:"index"`
+	ImageCameraModel  string  `gorm:"index"`
+	ImageLensModel    string  `gorm:"index"`
+	ImageFocalLength  float64 `gorm:"index"`
+	ImageAperture     float64 `gorm:"index"`
+	ImageShutterSpeed float64 `gorm:"index"`
+	ImageISO          int     `gorm:"index"`
+	ImageFlash        *bool   `gorm:"index"`
+	ImageWhiteBalance string  `gorm:"index"`
+	ImageOrientation  int
+	ImageSoftware     string
+}
+
+func (imageV11) TableName() string {
+	return "images"
+}
+
+type imageV12 struct {
+	ImageMetadata Metadata
+}
+
+func (imageV12) TableName() string {
+	return "images"
+}
+
+type imageV13 struct {
+	ImageID            int `gorm:"primary_key"`
+	ImageDateTime      string
+	ImageTakenAt       *time.Time `gorm:"index"`
+	ImageTakenAtSource string
+	ImageTimeZone      string
+}
+
+func (imageV13) TableName() string {
+	return "images"
+}
+
+type jobV14 struct {
+	JobOwnerID uint `gorm:"index"`
+}
+
+func (jobV14) TableName() string {
+	return "jobs"
+}
+
+type jobV15 struct {
+	JobHeartbeatAt *time.Time
+}
+
+func (jobV15) TableName() string {
+	return "jobs"
+}
+
+var migrations = []Migration{
+	{
+		// Databases set up before migrations existed already have the
+		// table, so the baseline only creates it when it is missing.
+		Version: 1,
+		Name:    "create_images",
+		Up: func(tx *gorm.DB) error {
+			if tx.HasTable(&imageV1{}) {
+				return nil
+			}
+			return tx.CreateTable(&imageV1{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&imageV1{}).Error
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create_jobs",
+		Up: func(tx *gorm.DB) error {
+			if tx.HasTable(&jobV2{}) {
+				return nil
+			}
+			return tx.CreateTable(&jobV2{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&jobV2{}).Error
+		},
+	},
+	{
+		// Adds numeric coordinates and fills them from the free-form
+		// image_lat and image_lon strings where those can be parsed.
+		Version: 3,
+		Name:    "add_image_coordinates",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&imageV3{}).Error; err != nil {
+				return err
+			}
+
+			var rows []struct {
+				ImageID  int
+				ImageLat string
+				ImageLon string
+			}
+			err := tx.Table("images").
+				Select("image_id, image_lat, image_lon").
+				Where("image_lat <> '' AND image_lon <> ''").
+				Scan(&rows).Error
+			if err != nil {
+				return err
+			}
+
+			for _, row := range rows {
+				lat, latErr := ParseCoordinate(row.ImageLat)
+				lon, lonErr := ParseCoordinate(row.ImageLon)
+				if latErr != nil || lonErr != nil || !ValidCoordinates(lat, lon) {
+					continue
+				}
+
+				err := tx.Table("images").Where("image_id = ?", row.ImageID).
+					Updates(map[string]interface{}{"image_latitude": lat, "image_longitude": lon}).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			columns := []string{"image_latitude", "image_longitude"}
+			if err := removeColumnIndexes(tx, &imageV3{}, columns...); err != nil {
+				return err
+			}
+			return dropColumns(tx, &imageV3{}, columns...)
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create_users",
+		Up: func(tx *gorm.DB) error {
+			return createTables(tx, &userV4{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&userV4{}).Error
+		},
+	},
+	{
+		// Images from before accounts existed have no owner and stay
+		// visible to everyone.
+		Version: 5,
+		Name:    "add_image_ownership",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&imageV5{}).Error; err != nil {
+				return err
+			}
+			return tx.Table("images").
+				Where("image_visibility IS NULL OR image_visibility = ''").
+				Updates(map[string]interface{}{"image_owner_id": 0, "image_visibility": VisibilityPublic}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			columns := []string{"image_owner_id", "image_visibility"}
+			if err := removeColumnIndexes(tx, &imageV5{}, columns...); err != nil {
+				return err
+			}
+			return dropColumns(tx, &imageV5{}, columns...)
+		},
+	},
+	{
+		// Users from before roles existed could already add and change
+		// images, so they become editors.
+		Version: 6,
+		Name:    "add_user_roles",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&userV6{}).Error; err != nil {
+				return err
+			}
+			return tx.Table("users").
+				Where("user_role IS NULL OR user_role = ''").
+				Updates(map[string]interface{}{"user_role": RoleEditor, "user_disabled": false}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, &userV6{}, "user_role", "user_disabled")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "create_token_tables",
+		Up: func(tx *gorm.DB) error {
+			return createTables(tx, &refreshTokenV7{}, &revokedTokenV7{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&refreshTokenV7{}, &revokedTokenV7{}).Error
+		},
+	},
+	{
+		Version: 8,
+		Name:    "create_api_keys",
+		Up: func(tx *gorm.DB) error {
+			return createTables(tx, &apiKeyV8{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.DropTableIfExists(&apiKeyV8{}).Error
+		},
+	},
+	{
+		// Adds content hashes and lets different files share a name. The
+		// unique constraint on image_file_name was created with the column,
+		// so its name depends on the database, and SQLite can only lose it
+		// by rebuilding the table. Existing images get their hashes when
+		// they are reindexed.
+		Version: 9,
+		Name:    "add_image_hash",
+		Up: func(tx *gorm.DB) error {
+			switch tx.Dialect().GetName() {
+			case "sqlite3":
+				return rebuildSQLiteTable(tx, &imageV9{})
+			case "postgres":
+				if err := tx.Exec("ALTER TABLE images DROP CONSTRAINT IF EXISTS images_image_file_name_key").Error; err != nil {
+					return err
+				}
+			case "mysql":
+				if tx.Dialect().HasIndex("images", "image_file_name") {
+					if err := tx.Exec("ALTER TABLE images DROP INDEX image_file_name").Error; err != nil {
+						return err
+					}
+				}
+			}
+			return tx.AutoMigrate(&imageV9{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if tx.Dialect().GetName() == "sqlite3" {
+				return rebuildSQLiteTable(tx, &imageV5Full{})
+			}
+
+			if err := removeColumnIndexes(tx, &imageV9{}, "image_hash", "image_file_name"); err != nil {
+				return err
+			}
+			if err := dropColumns(tx, &imageV9{}, "image_hash"); err != nil {
+				return err
+			}
+
+			switch tx.Dialect().GetName() {
+			case "postgres":
+				return tx.Exec("ALTER TABLE images ADD CONSTRAINT images_image_file_name_key UNIQUE (image_file_name)").Error
+			case "mysql":
+				if tx.Dialect().HasIndex("images", "image_file_name") {
+					return nil
+				}
+				return tx.Exec("ALTER TABLE images ADD UNIQUE INDEX image_file_name (image_file_name)").Error
+			}
+			return nil
+		},
+	},
+	{
+		// Similar images are found through an in-memory index, so the
+		// perceptual hash needs no database index. Existing images get
+		// theirs when they are reindexed.
+		Version: 10,
+		Name:    "add_image_perceptual_hash",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&imageV10{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, &imageV10{}, "image_perceptual_hash")
+		},
+	},
+	{
+		// Existing images get their camera settings when they are
+		// reindexed.
+		Version: 11,
+		Name:    "add_image_camera_settings",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&imageV11{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			indexed := []string{
+				"image_camera_make", "image_camera_model", "image_lens_model",
+				"image_focal_length", "image_aperture", "image_shutter_speed",
+				"image_iso", "image_flash", "image_white_balance"}
+			if err := removeColumnIndexes(tx, &imageV11{}, indexed...); err != nil {
+				return err
+			}
+			return dropColumns(tx, &imageV11{}, append(indexed, "image_orientation", "image_software")...)
+		},
+	},
+	{
+		// Metadata is queried with the database's JSON functions, so it
+		// gets the native JSON type where there is one. SQLite keeps JSON
+		// in a text column. Existing images get theirs when they are
+		// reindexed.
+		Version: 12,
+		Name:    "add_image_metadata",
+		Up: func(tx *gorm.DB) error {
+			columnType := "text"
+			switch tx.Dialect().GetName() {
+			case "postgres":
+				columnType = "jsonb"
+			case "mysql":
+				columnType = "json"
+			}
+			if tx.Dialect().HasColumn("images", "image_metadata") {
+				return nil
+			}
+			return tx.Exec("ALTER TABLE images ADD COLUMN image_metadata " + columnType).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, &imageV12{}, "image_metadata")
+		},
+	},
+	{
+		// Images already stored take their capture time from ImageDateTime,
+		// parsed as a date time given by a caller, until they are
+		// reindexed.
+		Version: 13,
+		Name:    "add_image_taken_at",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&imageV13{}).Error; err != nil {
+				return err
+			}
+
+			var images []imageV13
+			if err := tx.Where("image_date_time <> ''").Find(&images).Error; err != nil {
+				return err
+			}
+			for _, image := range images {
+				takenAt, hasZone, err := ParseImageDateTime(image.ImageDateTime)
+				if err != nil {
+					continue
+				}
+				err = tx.Model(&image).Updates(map[string]interface{}{
+					"image_taken_at":        takenAt.UTC(),
+					"image_taken_at_source": TakenAtImageDateTime,
+					"image_time_zone":       CaptureTimeZone(takenAt, hasZone)}).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := removeColumnIndexes(tx, &imageV13{}, "image_taken_at"); err != nil {
+				return err
+			}
+			return dropColumns(tx, &imageV13{}, "image_taken_at", "image_taken_at_source", "image_time_zone")
+		},
+	},
+	{
+		// Jobs queued before this have no owner and only admins can see
+		// them.
+		Version: 14,
+		Name:    "add_job_owner",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&jobV14{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := removeColumnIndexes(tx, &jobV14{}, "job_owner_id"); err != nil {
+				return err
+			}
+			return dropColumns(tx, &jobV14{}, "job_owner_id")
+		},
+	},
+	{
+		// Jobs left running without a heartbeat are queued again when the
+		// workers start.
+		Version: 15,
+		Name:    "add_job_heartbeat",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&jobV15{}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return dropColumns(tx, &jobV15{}, "job_heartbeat_at")
+		},
+	},
+}
+
+// MigrateUp applies every pending migration in order and returns the ones
+// it applied.
+func MigrateUp() ([]Migration, error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range sortedMigrations() {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		err := runMigration(migration, migration.Up, func(tx *gorm.DB) error {
+			return tx.Create(&SchemaMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the most recently applied migration. It returns nil
+// when there is nothing to revert.
+func MigrateDown() (*Migration, error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	all := sortedMigrations()
+	for i := len(all) - 1; i >= 0; i-- {
+		migration := all[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		err := runMigration(migration, migration.Down, func(tx *gorm.DB) error {
+			return tx.Where("version = ?", migration.Version).Delete(&SchemaMigration{}).Error
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &migration, nil
+	}
+	return nil, nil
+}
+
+// MigrationStatuses lists every known migration with its applied state.
+func MigrationStatuses() ([]MigrationStatus, error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, migration := range sortedMigrations() {
+		record, ok := applied[migration.Version]
+		statuses = append(statuses, MigrationStatus{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Applied:   ok,
+			AppliedAt: record.AppliedAt})
+	}
+	return statuses, nil
+}
+
+func sortedMigrations() []Migration {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	return sorted
+}
+
+// appliedMigrations creates schema_migrations if needed and returns its rows
+// keyed by version.
+func appliedMigrations() (map[int]SchemaMigration, error) {
+	if err := DB.AutoMigrate(&SchemaMigration{}).Error; err != nil {
+		return nil, err
+	}
+
+	var records []SchemaMigration
+	if err := DB.Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	applied := map[int]SchemaMigration{}
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// runMigration runs change and then record in one transaction.
+func runMigration(migration Migration, change func(tx *gorm.DB) error, record func(tx *gorm.DB) error) error {
+	tx := DB.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if err := change(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+	}
+
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+	}
+
+	return tx.Commit().Error
+}
+
+// createTables creates the snapshots' tables that do not exist yet.
+func createTables(tx *gorm.DB, snapshots ...interface{}) error {
+	for _, snapshot := range snapshots {
+		if tx.HasTable(snapshot) {
+			continue
+		}
+		if err := tx.CreateTable(snapshot).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeColumnIndexes removes the indexes gorm made for the columns of the
+// snapshot's table, where they still exist.
+func removeColumnIndexes(tx *gorm.DB, snapshot interface{}, columns ...string) error {
+	table := tx.NewScope(snapshot).TableName()
+	for _, column := range columns {
+		index := fmt.Sprintf("idx_%s_%s", table, column)
+		if !tx.Dialect().HasIndex(table, index) {
+			continue
+		}
+		if err := tx.Model(snapshot).RemoveIndex(index).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropColumns drops the columns of the snapshot's table that still exist.
+func dropColumns(tx *gorm.DB, snapshot interface{}, columns ...string) error {
+	table := tx.NewScope(snapshot).TableName()
+	for _, column := range columns {
+		if !tx.Dialect().HasColumn(table, column) {
+			continue
+		}
+		if err := tx.Model(snapshot).DropColumn(column).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebuildSQLiteTable recreates the snapshot's table from the snapshot and
+// copies over the columns the old and new tables share. SQLite cannot drop
+// constraints, so this is how they are changed there.
+func rebuildSQLiteTable(tx *gorm.DB, snapshot interface{}) error {
+	table := tx.NewScope(snapshot).TableName()
+	old := table + "_old"
+
+	if err := tx.Exec(fmt.Sprintf("ALTER TABLE %q RENAME TO %q", table, old)).Error; err != nil {
+		return err
+	}
+
+	// Index names are global, so the old table's indexes have to go before
+	// the new table creates its own.
+	rows, err := tx.Raw("SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND sql IS NOT NULL", old).Rows()
+	if err != nil {
+		return err
+	}
+	var indexes []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		indexes = append(indexes, name)
+	}
+	rows.Close()
+
+	for _, index := range indexes {
+		if err := tx.Exec(fmt.Sprintf("DROP INDEX %q", index)).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := tx.CreateTable(snapshot).Error; err != nil {
+		return err
+	}
+
+	oldColumns, err := sqliteColumns(tx, old)
+	if err != nil {
+		return err
+	}
+	newColumns, err := sqliteColumns(tx, table)
+	if err != nil {
+		return err
+	}
+
+	var shared []string
+	for _, column := range newColumns {
+		for _, oldColumn := range oldColumns {
+			if column == oldColumn {
+				shared = append(shared, fmt.Sprintf("%q", column))
+				break
+			}
+		}
+	}
+
+	columns := strings.Join(shared, ", ")
+	err = tx.Exec(fmt.Sprintf("INSERT INTO %q (%s) SELECT %s FROM %q", table, columns, columns, old)).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Exec(fmt.Sprintf("DROP TABLE %q", old)).Error
+}
+
+func sqliteColumns(tx *gorm.DB, table string) ([]string, error) {
+	rows, err := tx.Raw(fmt.Sprintf("PRAGMA table_info(%q)", table)).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			dataType  string
+			notNull   bool
+			dfltValue interface{}
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &dataType, &notNull, &dfltValue, &pk); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+// migratedImage is the part of an image every schema version since the
+// owner was added keeps.
+type migratedImage struct {
+	ImageID          int
+	ImageFileName    string
+	ImageDirLocation string
+	ImageOwnerID     uint
+	ImageVisibility  string
+}
+
+func migratedImages(t *testing.T) []migratedImage {
+	t.Helper()
+
+	var images []migratedImage
+	err := DB.Raw("SELECT image_id, image_file_name, image_dir_location, image_owner_id, image_visibility FROM images ORDER BY image_id").
+		Scan(&images).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return images
+}
+
+func appliedVersion(t *testing.T) int {
+	t.Helper()
+
+	statuses, err := MigrationStatuses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	version := 0
+	for _, status := range statuses {
+		if status.Applied {
+			version = status.Version
+		}
+	}
+	return version
+}
+
+// Every migration can be reverted and applied again, and the images table
+// rebuilds of version 9 on SQLite keep its rows.
+func TestMigrateDownAndUp(t *testing.T) {
+	store := newSQLiteStore(t)
+	DB.LogMode(false)
+	latest := appliedVersion(t)
+
+	for _, image := range []Image{
+		{ImageFileName: "a.jpg", ImageDirLocation: "/library/a.jpg", ImageOwnerID: 1, ImageVisibility: VisibilityPublic, ImageHash: "a"},
+		{ImageFileName: "b.jpg", ImageDirLocation: "/library/b.jpg", ImageOwnerID: 2, ImageVisibility: VisibilityPrivate, ImageHash: "b"},
+	} {
+		if err := store.Create(&image); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := migratedImages(t)
+
+	// Down to version 8, from before file names could repeat.
+	for appliedVersion(t) > 8 {
+		if _, err := MigrateDown(); err != nil {
+			t.Fatal(err)
+		}
+		if got := migratedImages(t); !reflect.DeepEqual(got, want) {
+			t.Fatalf("after reverting to version %d images are %+v, want %+v", appliedVersion(t), got, want)
+		}
+	}
+	if DB.Dialect().HasColumn("images", "image_hash") {
+		t.Error("image_hash still exists at version 8")
+	}
+	if err := DB.Exec("INSERT INTO images (image_file_name) VALUES ('a.jpg')").Error; err == nil {
+		t.Error("file name repeated at version 8")
+	}
+
+	if _, err := MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	if version := appliedVersion(t); version != latest {
+		t.Errorf("version %d after MigrateUp, want %d", version, latest)
+	}
+	if got := migratedImages(t); !reflect.DeepEqual(got, want) {
+		t.Fatalf("after MigrateUp images are %+v, want %+v", got, want)
+	}
+	repeated := Image{ImageFileName: "a.jpg", ImageDirLocation: "/library/other/a.jpg"}
+	if err := store.Create(&repeated); err != nil {
+		t.Errorf("file name not allowed to repeat: %v", err)
+	}
+
+	// Version 9 cannot be reverted while file names repeat, and the
+	// failed rebuild leaves the table as it was.
+	for appliedVersion(t) > 9 {
+		if _, err := MigrateDown(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := MigrateDown(); err == nil {
+		t.Fatal("version 9 reverted with a repeated file name")
+	}
+	if version := appliedVersion(t); version != 9 {
+		t.Errorf("version %d after the failed revert, want 9", version)
+	}
+	if got := migratedImages(t); len(got) != len(want)+1 {
+		t.Errorf("%d images after the failed revert, want %d", len(got), len(want)+1)
+	}
+	if err := DB.Exec("DELETE FROM images WHERE image_id = ?", repeated.ImageID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// All the way down and up again.
+	for appliedVersion(t) > 0 {
+		if _, err := MigrateDown(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if DB.HasTable("images") || DB.HasTable("jobs") || DB.HasTable("users") {
+		t.Error("tables left after reverting every migration")
+	}
+	if migration, err := MigrateDown(); migration != nil || err != nil {
+		t.Errorf("MigrateDown with nothing applied = %v, %v", migration, err)
+	}
+
+	if _, err := MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	if version := appliedVersion(t); version != latest {
+		t.Errorf("version %d after MigrateUp, want %d", version, latest)
+	}
+	if applied, err := MigrateUp(); len(applied) != 0 || err != nil {
+		t.Errorf("second MigrateUp applied %d migrations, %v", len(applied), err)
+	}
+}
@@ -0,0 +1,423 @@
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ImageFilter selects images in Search. Zero valued fields match every
+// image. TakenAfter and TakenBefore are compared with ImageTakenAt and only
+// match images with a capture time. Bounds only
+// matches images with coordinates. The camera settings match exactly, and
+// Flash only matches images known to have fired or not. Metadata matches
+// images whose raw metadata tags have the given text values. Access, when
+// set, leaves out the images its user may not see.
+type ImageFilter struct {
+	FileName        string
+	DirLocation     string
+	Year            int
+	Month           int
+	Day             int
+	Type            string
+	WidthMin        int
+	WidthMax        int
+	HeightMin       int
+	HeightMax       int
+	MegaPixelsMin   float64
+	MegaPixelsMax   float64
+	TakenAfter      time.Time
+	TakenBefore     time.Time
+	Bounds          *GeoBounds
+	OwnerID         uint
+	Visibility      string
+	Hash            string
+	CameraMake      string
+	CameraModel     string
+	LensModel       string
+	FocalLengthMin  float64
+	FocalLengthMax  float64
+	ApertureMin     float64
+	ApertureMax     float64
+	ShutterSpeedMin float64
+	ShutterSpeedMax float64
+	ISOMin          int
+	ISOMax          int
+	Flash           *bool
+	WhiteBalance    string
+	Orientation     int
+	Software        string
+	Metadata        map[string]string
+	Access          *ImageAccess
+}
+
+// ImageAccess is the caller a search is made for. A zero UserID is an
+// anonymous caller.
+type ImageAccess struct {
+	UserID uint
+}
+
+// ImageQuery is a filtered, sorted page of images. Sort is a column name
+// from SortColumns and defaults to image_id. When Cursor is set it replaces
+// Offset and the page starts right after (or, for a Before cursor, ends
+// right before) the cursor position.
+type ImageQuery struct {
+	Filter ImageFilter
+	Sort   string
+	Desc   bool
+	Limit  int
+	Offset int
+	Cursor *ImageCursor
+}
+
+// ImageCursor is a position in a sorted image list: the sort column value
+// and ID of the image it points at.
+type ImageCursor struct {
+	Value  interface{} `json:"v"`
+	ID     int         `json:"id"`
+	Before bool        `json:"b,omitempty"`
+}
+
+// imageColumns maps each sortable images column name to its Image field
+// index. Nullable columns are left out because the databases disagree on
+// where NULLs sort, which would break cursors, and so is the metadata JSON.
+var imageColumns = map[string]int{}
+
+func init() {
+	imageType := reflect.TypeOf(Image{})
+	for i := 0; i < imageType.NumField(); i++ {
+		if kind := imageType.Field(i).Type.Kind(); kind == reflect.Ptr || kind == reflect.Map {
+			continue
+		}
+		imageColumns[gorm.ToColumnName(imageType.Field(i).Name)] = i
+	}
+}
+
+// SortColumns lists the columns images can be sorted by.
+func SortColumns() []string {
+	var columns []string
+	for column := range imageColumns {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// IsSortColumn reports whether images can be sorted by column.
+func IsSortColumn(column string) bool {
+	_, ok := imageColumns[column]
+	return ok
+}
+
+// CursorFor returns the cursor pointing at image in the query's sort order.
+func (q ImageQuery) CursorFor(image Image, before bool) ImageCursor {
+	return ImageCursor{
+		Value:  reflect.ValueOf(image).Field(imageColumns[q.sortColumn()]).Interface(),
+		ID:     image.ImageID,
+		Before: before}
+}
+
+func (q ImageQuery) sortColumn() string {
+	if q.Sort == "" {
+		return "image_id"
+	}
+	return q.Sort
+}
+
+// scanDesc reports the direction rows are read in. A Before cursor reads
+// backwards from the cursor and the page is reversed afterwards.
+func (q ImageQuery) scanDesc() bool {
+	return q.Desc != (q.Cursor != nil && q.Cursor.Before)
+}
+
+// where adds the filter conditions to a gorm query.
+func (f ImageFilter) where(query *gorm.DB) *gorm.DB {
+	if f.FileName != "" {
+		query = query.Where("image_file_name = ?", f.FileName)
+	}
+	if f.DirLocation != "" {
+		query = query.Where("image_dir_location = ?", f.DirLocation)
+	}
+	if f.Year != 0 {
+		query = query.Where("image_year = ?", f.Year)
+	}
+	if f.Month != 0 {
+		query = query.Where("image_month = ?", f.Month)
+	}
+	if f.Day != 0 {
+		query = query.Where("image_day = ?", f.Day)
+	}
+	if f.Type != "" {
+		query = query.Where("image_type = ?", f.Type)
+	}
+	if f.WidthMin != 0 {
+		query = query.Where("image_width >= ?", f.WidthMin)
+	}
+	if f.WidthMax != 0 {
+		query = query.Where("image_width <= ?", f.WidthMax)
+	}
+	if f.HeightMin != 0 {
+		query = query.Where("image_height >= ?", f.HeightMin)
+	}
+	if f.HeightMax != 0 {
+		query = query.Where("image_height <= ?", f.HeightMax)
+	}
+	if f.MegaPixelsMin != 0 {
+		query = query.Where("image_mega_pixels >= ?", f.MegaPixelsMin)
+	}
+	if f.MegaPixelsMax != 0 {
+		query = query.Where("image_mega_pixels <= ?", f.MegaPixelsMax)
+	}
+	if !f.TakenAfter.IsZero() {
+		query = query.Where("image_taken_at >= ?", f.TakenAfter.UTC())
+	}
+	if !f.TakenBefore.IsZero() {
+		query = query.Where("image_taken_at < ?", f.TakenBefore.UTC())
+	}
+	if f.OwnerID != 0 {
+		query = query.Where("image_owner_id = ?", f.OwnerID)
+	}
+	if f.Visibility != "" {
+		query = query.Where("image_visibility = ?", f.Visibility)
+	}
+	if f.Hash != "" {
+		query = query.Where("image_hash = ?", f.Hash)
+	}
+	if f.CameraMake != "" {
+		query = query.Where("image_camera_make = ?", f.CameraMake)
+	}
+	if f.CameraModel != "" {
+		query = query.Where("image_camera_model = ?", f.CameraModel)
+	}
+	if f.LensModel != "" {
+		query = query.Where("image_lens_model = ?", f.LensModel)
+	}
+	if f.FocalLengthMin != 0 {
+		query = query.Where("image_focal_length >= ?", f.FocalLengthMin)
+	}
+	if f.FocalLengthMax != 0 {
+		query = query.Where("image_focal_length <= ?", f.FocalLengthMax)
+	}
+	if f.ApertureMin != 0 {
+		query = query.Where("image_aperture >= ?", f.ApertureMin)
+	}
+	if f.ApertureMax != 0 {
+		query = query.Where("image_aperture <= ?", f.ApertureMax)
+	}
+	if f.ShutterSpeedMin != 0 {
+		query = query.Where("image_shutter_speed >= ?", f.ShutterSpeedMin)
+	}
+	if f.ShutterSpeedMax != 0 {
+		query = query.Where("image_shutter_speed <= ?", f.ShutterSpeedMax)
+	}
+	if f.ISOMin != 0 {
+		query = query.Where("image_iso >= ?", f.ISOMin)
+	}
+	if f.ISOMax != 0 {
+		query = query.Where("image_iso <= ?", f.ISOMax)
+	}
+	if f.Flash != nil {
+		query = query.Where("image_flash = ?", *f.Flash)
+	}
+	if f.WhiteBalance != "" {
+		query = query.Where("image_white_balance = ?", f.WhiteBalance)
+	}
+	if f.Orientation != 0 {
+		query = query.Where("image_orientation = ?", f.Orientation)
+	}
+	if f.Software != "" {
+		query = query.Where("image_software = ?", f.Software)
+	}
+	if len(f.Metadata) > 0 {
+		for _, key := range f.metadataKeys() {
+			condition, args := metadataCondition(query.Dialect().GetName(), key, f.Metadata[key])
+			query = query.Where(condition, args...)
+		}
+	}
+	if a := f.Access; a != nil {
+		if a.UserID == 0 {
+			query = query.Where("image_visibility = ?", VisibilityPublic)
+		} else {
+			query = query.Where("image_visibility IN (?) OR image_owner_id = ?",
+				[]string{VisibilityPublic, VisibilityShared}, a.UserID)
+		}
+	}
+	if b := f.Bounds; b != nil {
+		query = query.Where("image_latitude BETWEEN ? AND ?", b.MinLat, b.MaxLat)
+		if b.MinLon <= b.MaxLon {
+			query = query.Where("image_longitude BETWEEN ? AND ?", b.MinLon, b.MaxLon)
+		} else {
+			query = query.Where("image_longitude >= ? OR image_longitude <= ?", b.MinLon, b.MaxLon)
+		}
+	}
+	return query
+}
+
+func (f ImageFilter) matches(image Image) bool {
+	return (f.FileName == "" || image.ImageFileName == f.FileName) &&
+		(f.DirLocation == "" || image.ImageDirLocation == f.DirLocation) &&
+		(f.Year == 0 || image.ImageYear == f.Year) &&
+		(f.Month == 0 || image.ImageMonth == f.Month) &&
+		(f.Day == 0 || image.ImageDay == f.Day) &&
+		(f.Type == "" || image.ImageType == f.Type) &&
+		(f.WidthMin == 0 || image.ImageWidth >= f.WidthMin) &&
+		(f.WidthMax == 0 || image.ImageWidth <= f.WidthMax) &&
+		(f.HeightMin == 0 || image.ImageHeight >= f.HeightMin) &&
+		(f.HeightMax == 0 || image.ImageHeight <= f.HeightMax) &&
+		(f.MegaPixelsMin == 0 || image.ImageMegaPixels >= f.MegaPixelsMin) &&
+		(f.MegaPixelsMax == 0 || image.ImageMegaPixels <= f.MegaPixelsMax) &&
+		(f.TakenAfter.IsZero() || image.ImageTakenAt != nil && !image.ImageTakenAt.Before(f.TakenAfter)) &&
+		(f.TakenBefore.IsZero() || image.ImageTakenAt != nil && image.ImageTakenAt.Before(f.TakenBefore)) &&
+		(f.OwnerID == 0 || image.ImageOwnerID == f.OwnerID) &&
+		(f.Visibility == "" || image.ImageVisibility == f.Visibility) &&
+		(f.Hash == "" || image.ImageHash == f.Hash) &&
+		(f.CameraMake == "" || image.ImageCameraMake == f.CameraMake) &&
+		(f.CameraModel == "" || image.ImageCameraModel == f.CameraModel) &&
+		(f.LensModel == "" || image.ImageLensModel == f.LensModel) &&
+		(f.FocalLengthMin == 0 || image.ImageFocalLength >= f.FocalLengthMin) &&
+		(f.FocalLengthMax == 0 || image.ImageFocalLength <= f.FocalLengthMax) &&
+		(f.ApertureMin == 0 || image.ImageAperture >= f.ApertureMin) &&
+		(f.ApertureMax == 0 || image.ImageAperture <= f.ApertureMax) &&
+		(f.ShutterSpeedMin == 0 || image.ImageShutterSpeed >= f.ShutterSpeedMin) &&
+		(f.ShutterSpeedMax == 0 || image.ImageShutterSpeed <= f.ShutterSpeedMax) &&
+		(f.ISOMin == 0 || image.ImageISO >= f.ISOMin) &&
+		(f.ISOMax == 0 || image.ImageISO <= f.ISOMax) &&
+		(f.Flash == nil || image.ImageFlash != nil && *image.ImageFlash == *f.Flash) &&
+		(f.WhiteBalance == "" || image.ImageWhiteBalance == f.WhiteBalance) &&
+		(f.Orientation == 0 || image.ImageOrientation == f.Orientation) &&
+		(f.Software == "" || image.ImageSoftware == f.Software) &&
+		f.matchesMetadata(image) &&
+		(f.Access == nil || image.VisibleTo(f.Access.UserID)) &&
+		(f.Bounds == nil || image.ImageLatitude != nil && image.ImageLongitude != nil &&
+			f.Bounds.Contains(*image.ImageLatitude, *image.ImageLongitude))
+}
+
+// metadataKeys returns the metadata filter keys in a fixed order.
+func (f ImageFilter) metadataKeys() []string {
+	var keys []string
+	for key := range f.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f ImageFilter) matchesMetadata(image Image) bool {
+	for key, value := range f.Metadata {
+		tag, ok := image.ImageMetadata[key]
+		if !ok || !metadataMatches(tag, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// page applies the sort order, cursor and limits to a filtered gorm query.
+func (q ImageQuery) page(query *gorm.DB) *gorm.DB {
+	column := q.sortColumn()
+	desc := q.scanDesc()
+
+	direction, compare := "ASC", ">"
+	if desc {
+		direction, compare = "DESC", "<"
+	}
+
+	if q.Cursor != nil {
+		query = query.Where(
+			fmt.Sprintf("%s %s ? OR (%s = ? AND image_id %s ?)", column, compare, column, compare),
+			q.Cursor.Value, q.Cursor.Value, q.Cursor.ID)
+	} else if q.Offset > 0 {
+		query = query.Offset(q.Offset)
+	}
+
+	query = query.Order(fmt.Sprintf("%s %s", column, direction))
+	if column != "image_id" {
+		query = query.Order(fmt.Sprintf("image_id %s", direction))
+	}
+
+	if q.Limit > 0 {
+		query = query.Limit(q.Limit)
+	}
+	return query
+}
+
+// pageSlice sorts, positions and limits images the same way page does for
+// the database.
+func (q ImageQuery) pageSlice(images []Image) []Image {
+	field := imageColumns[q.sortColumn()]
+	desc := q.scanDesc()
+
+	less := func(a, b Image) bool {
+		c := compareValues(reflect.ValueOf(a).Field(field), reflect.ValueOf(b).Field(field))
+		if c == 0 {
+			c = a.ImageID - b.ImageID
+		}
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		return less(images[i], images[j])
+	})
+
+	if q.Cursor != nil {
+		var cursorImage Image
+		cursorValue := reflect.ValueOf(&cursorImage).Elem().Field(field)
+		if value := reflect.ValueOf(q.Cursor.Value); value.IsValid() && value.Type().ConvertibleTo(cursorValue.Type()) {
+			cursorValue.Set(value.Convert(cursorValue.Type()))
+		}
+		cursorImage.ImageID = q.Cursor.ID
+
+		start := sort.Search(len(images), func(i int) bool {
+			return less(cursorImage, images[i])
+		})
+		images = images[start:]
+	} else if q.Offset > 0 {
+		if q.Offset >= len(images) {
+			return []Image{}
+		}
+		images = images[q.Offset:]
+	}
+
+	if q.Limit > 0 && len(images) > q.Limit {
+		images = images[:q.Limit]
+	}
+	return images
+}
+
+func compareValues(a, b reflect.Value) int {
+	switch a.Kind() {
+	case reflect.Int:
+		return sign(float64(a.Int() - b.Int()))
+	case reflect.Uint:
+		return sign(float64(a.Uint()) - float64(b.Uint()))
+	case reflect.Float64:
+		return sign(a.Float() - b.Float())
+
+	default:
+		return strings.Compare(fmt.Sprint(a.Interface()), fmt.Sprint(b.Interface()))
+	}
+}
+
+func sign(f float64) int {
+	switch {
+	case f < 0:
+		return -1
+	case f > 0:
+		return 1
+	}
+	return 0
+}
+
+// reverseImages undoes the backwards scan of a Before cursor.
+func reverseImages(images []Image) {
+	for i, j := 0, len(images)-1; i < j; i, j = i+1, j-1 {
+		images[i], images[j] = images[j], images[i]
+	}
+}
@@ -0,0 +1,211 @@
+package models
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Cursors must page through images whose sort values are equal without
+// skipping or repeating any, in both directions and on both stores.
+func TestCursorPagingWithEqualSortValues(t *testing.T) {
+	stores := map[string]func(t *testing.T) ImageStore{
+		"memory": func(t *testing.T) ImageStore { return NewMemoryImageStore() },
+		"gorm":   newSQLiteStore,
+	}
+
+	tests := []struct {
+		sort string
+		desc bool
+	}{
+		{"", false},
+		{"", true},
+		{"image_width", false},
+		{"image_width", true},
+		{"image_file_name", false},
+		{"image_file_name", true},
+		{"image_mega_pixels", false},
+		{"image_mega_pixels", true},
+	}
+
+	for storeName, newStore := range stores {
+		for _, test := range tests {
+			name := storeName + "/" + test.sort
+			if test.desc {
+				name += "/desc"
+			}
+			t.Run(name, func(t *testing.T) {
+				store := newStore(t)
+				images := createPagingImages(t, store)
+				want := sortedIDs(images, test.sort, test.desc)
+
+				for _, limit := range []int{1, 2, 3, len(images)} {
+					query := ImageQuery{Sort: test.sort, Desc: test.desc, Limit: limit}
+
+					// Forward with next cursors.
+					var pages [][]int
+					var got []int
+					for {
+						page := searchIDs(t, store, query)
+						if len(page) == 0 {
+							break
+						}
+						pages = append(pages, page)
+						got = append(got, page...)
+						if len(got) > len(images) {
+							t.Fatalf("limit %d: next cursors did not stop after %v", limit, got)
+						}
+
+						last := findImage(images, page[len(page)-1])
+						query.Cursor = roundTrip(t, query.CursorFor(last, false))
+					}
+					if !equalIDs(got, want) {
+						t.Fatalf("limit %d: next cursors gave %v, want %v", limit, got, want)
+					}
+
+					// Back with prev cursors from the start of each page.
+					for i := len(pages) - 1; i > 0; i-- {
+						first := findImage(images, pages[i][0])
+						query.Cursor = roundTrip(t, query.CursorFor(first, true))
+						if page := searchIDs(t, store, query); !equalIDs(page, pages[i-1]) {
+							t.Fatalf("limit %d: prev cursor from page %d gave %v, want %v", limit, i, page, pages[i-1])
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// createPagingImages stores images with many equal widths, names and sizes.
+func createPagingImages(t *testing.T, store ImageStore) []Image {
+	t.Helper()
+
+	rows := []struct {
+		name       string
+		width      int
+		megaPixels float64
+	}{
+		{"b.jpg", 100, 1.5},
+		{"a.jpg", 100, 1.5},
+		{"b.jpg", 200, 0.5},
+		{"a.jpg", 100, 2},
+		{"c.jpg", 200, 0.5},
+		{"a.jpg", 300, 1.5},
+		{"b.jpg", 100, 0.5},
+	}
+
+	var images []Image
+	for _, row := range rows {
+		image := Image{ImageFileName: row.name, ImageWidth: row.width, ImageMegaPixels: row.megaPixels, ImageVisibility: VisibilityPublic}
+		if err := store.Create(&image); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		images = append(images, image)
+	}
+	return images
+}
+
+func newSQLiteStore(t *testing.T) ImageStore {
+	t.Helper()
+
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	db.DB().SetMaxOpenConns(1)
+
+	previous := DB
+	DB = db
+	t.Cleanup(func() {
+		DB = previous
+		db.Close()
+	})
+
+	if _, err := MigrateUp(); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	return NewGormImageStore(db)
+}
+
+func searchIDs(t *testing.T, store ImageStore, query ImageQuery) []int {
+	t.Helper()
+
+	images, _, err := store.Search(query)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	ids := []int{}
+	for _, image := range images {
+		ids = append(ids, image.ImageID)
+	}
+	return ids
+}
+
+// roundTrip passes the cursor through JSON, as it is in a page link.
+func roundTrip(t *testing.T, cursor ImageCursor) *ImageCursor {
+	t.Helper()
+
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var decoded ImageCursor
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	return &decoded
+}
+
+func sortedIDs(images []Image, column string, desc bool) []int {
+	sorted := append([]Image(nil), images...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		var c int
+		switch column {
+		case "image_width":
+			c = a.ImageWidth - b.ImageWidth
+		case "image_file_name":
+			c = strings.Compare(a.ImageFileName, b.ImageFileName)
+		case "image_mega_pixels":
+			c = sign(a.ImageMegaPixels - b.ImageMegaPixels)
+		}
+		if c == 0 {
+			c = a.ImageID - b.ImageID
+		}
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	var ids []int
+	for _, image := range sorted {
+		ids = append(ids, image.ImageID)
+	}
+	return ids
+}
+
+func findImage(images []Image, id int) Image {
+	for _, image := range images {
+		if image.ImageID == id {
+			return image
+		}
+	}
+	return Image{}
+}
+
+func equalIDs(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,33 +1,41 @@
 package models
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
 	"github.com/joho/godotenv"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
 )
 
 var DB *gorm.DB
 
 func ConnectDatabase() {
 
+	// Settings already in the environment win, which lets the API run
+	// without a .env file.
 	err := godotenv.Load(".env")
 
 	if err != nil {
-		log.Fatalf("Error loading .env file")
+		log.Println("No .env file loaded, using the environment")
 	}
 
 	Dbdriver := os.Getenv("DB_DRIVER")
-	DbHost := os.Getenv("DB_HOST")
-	DbUser := os.Getenv("DB_USER")
-	DbPassword := os.Getenv("DB_PASSWORD")
-	DbName := os.Getenv("DB_NAME")
-	DbPort := os.Getenv("DB_PORT")
 
-	DBURL := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8&parseTime=True&loc=Local", DbUser, DbPassword, DbHost, DbPort, DbName)
+	DBURL, err := databaseURL(Dbdriver)
+
+	if err != nil {
+		log.Fatal("configuration error:", err)
+	}
 
 	DB, err = gorm.Open(Dbdriver, DBURL)
 
@@ -38,30 +46,63 @@ func ConnectDatabase() {
 		fmt.Println("We are connected to the database ", Dbdriver)
 	}
 
-	DB.AutoMigrate(&Image{})
-	//DB.DropTableIfExists(&Vehicle{}, &Customer{}, &Tire{})
-	//DB.AutoMigrate(&Company{}).AddForeignKey("id", "customers(id)", "CASCADE", "CASCADE")
-	//DB.AutoMigrate(&Company{}).AddForeignKey("veh_id", "vehicles(v_id)", "CASCADE", "CASCADE")
-	// DB.AutoMigrate(&Company{})
-	// DB.AutoMigrate(&Customer{})
-	// DB.AutoMigrate(&Vehicle{})
-	// DB.AutoMigrate(&Tire{}).AddForeignKey("tire_id", "vehicles(v_id)", "CASCADE", "CASCADE")
-	// DB.Model(&Vehicle{}).AddForeignKey("veh_id", "customers(id)", "CASCADE", "CASCADE")
-
-	// DB, err = gorm.Open(Dbdriver, DBURL)
-
-	// dsn := "username:password@tcp(127.0.0.1:3306)/test"
+	if Dbdriver == "sqlite3" {
+		// SQLite allows a single writer, and an in-memory database only
+		// lives as long as its connection, so share one connection.
+		DB.DB().SetMaxOpenConns(1)
+	}
+}
 
-	// database, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+// databaseURL builds the connection string for driver from the DB_*
+// environment variables. For sqlite3, DB_NAME is the database file path;
+// leave it empty or set it to :memory: for an in-memory database.
+func databaseURL(driver string) (string, error) {
+	DbHost := os.Getenv("DB_HOST")
+	DbUser := os.Getenv("DB_USER")
+	DbPassword := os.Getenv("DB_PASSWORD")
+	DbName := os.Getenv("DB_NAME")
+	DbPort := os.Getenv("DB_PORT")
 
-	// if err != nil {
-	// 	panic("Failed to connect to database!")
-	// }
+	switch driver {
+	case "mysql":
+		return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8&parseTime=True&loc=Local", DbUser, DbPassword, DbHost, DbPort, DbName), nil
+	case "postgres":
+		sslMode := os.Getenv("DB_SSLMODE")
+		if sslMode == "" {
+			sslMode = "disable"
+		}
+		return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+			dsnValue(DbHost), dsnValue(DbPort), dsnValue(DbUser), dsnValue(DbPassword), dsnValue(DbName), dsnValue(sslMode)), nil
+	case "sqlite3":
+		if DbName == "" || DbName == ":memory:" {
+			return "file::memory:?cache=shared", nil
+		}
+		return DbName, nil
+	default:
+		return "", fmt.Errorf("unsupported DB_DRIVER %q, use mysql, postgres or sqlite3", driver)
+	}
+}
 
-	// err = database.AutoMigrate(&Book{})
-	// if err != nil {
-	// 	return
-	// }
+// dsnValue quotes a value for a postgres key=value connection string, so
+// spaces, quotes and backslashes in it are kept.
+func dsnValue(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
 
-	// DB = database
+// isUniqueViolation reports whether err is a database's refusal to store a
+// row that would break a unique index.
+func isUniqueViolation(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	return false
 }
@@ -0,0 +1,352 @@
+package models
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ErrImageNotFound is returned by an ImageStore when no image has the
+// requested ID.
+var ErrImageNotFound = errors.New("image not found")
+
+// ImageStore keeps the indexed images.
+type ImageStore interface {
+	Get(id int) (Image, error)
+	List() ([]Image, error)
+	Create(image *Image) error
+	// Update copies the non-zero fields of changes onto the image with the
+	// given ID and returns the result.
+	Update(id int, changes Image) (Image, error)
+	// Replace copies every field of image, zero valued or not, onto the
+	// image with the given ID except the fields named in keep, and returns
+	// the result.
+	Replace(id int, image Image, keep ...string) (Image, error)
+	Delete(id int) error
+	// Search returns the requested page of images and the number of images
+	// matching the filter across all pages.
+	Search(query ImageQuery) ([]Image, int, error)
+	// Duplicates returns a page of the groups of filtered images that share
+	// a content hash, largest first, and the number of groups.
+	Duplicates(filter ImageFilter, limit int, offset int) ([]DuplicateGroup, int, error)
+}
+
+// DuplicateGroup is a set of images with identical file contents.
+type DuplicateGroup struct {
+	ImageHash string
+	Images    []Image
+}
+
+// GormImageStore is an ImageStore backed by a gorm database.
+type GormImageStore struct {
+	db *gorm.DB
+}
+
+func NewGormImageStore(db *gorm.DB) *GormImageStore {
+	return &GormImageStore{db: db}
+}
+
+func (s *GormImageStore) Get(id int) (Image, error) {
+	var image Image
+	err := s.db.Where("image_id = ?", id).First(&image).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return image, ErrImageNotFound
+	}
+	return image, err
+}
+
+func (s *GormImageStore) List() ([]Image, error) {
+	var images []Image
+	err := s.db.Order("image_id").Find(&images).Error
+	return images, err
+}
+
+func (s *GormImageStore) Create(image *Image) error {
+	return s.db.Create(image).Error
+}
+
+func (s *GormImageStore) Update(id int, changes Image) (Image, error) {
+	image, err := s.Get(id)
+	if err != nil {
+		return image, err
+	}
+
+	if err := s.db.Model(&image).Updates(changes).Error; err != nil {
+		return image, err
+	}
+	return s.Get(id)
+}
+
+func (s *GormImageStore) Replace(id int, image Image, keep ...string) (Image, error) {
+	existing, err := s.Get(id)
+	if err != nil {
+		return existing, err
+	}
+
+	// Updates skips zero values when given a struct but not a map.
+	changes := map[string]interface{}{}
+	for _, field := range s.db.NewScope(&image).Fields() {
+		if field.IsPrimaryKey || field.IsIgnored || containsField(keep, field.Name) {
+			continue
+		}
+		changes[field.DBName] = field.Field.Interface()
+	}
+
+	if err := s.db.Model(&existing).Updates(changes).Error; err != nil {
+		return existing, err
+	}
+	return s.Get(id)
+}
+
+func (s *GormImageStore) Delete(id int) error {
+	result := s.db.Where("image_id = ?", id).Delete(&Image{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrImageNotFound
+	}
+	return nil
+}
+
+func (s *GormImageStore) Search(query ImageQuery) ([]Image, int, error) {
+	filtered := query.Filter.where(s.db.Model(&Image{}))
+
+	var total int
+	if err := filtered.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	images := []Image{}
+	if err := query.page(filtered).Find(&images).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if query.Cursor != nil && query.Cursor.Before {
+		reverseImages(images)
+	}
+	return images, total, nil
+}
+
+func (s *GormImageStore) Duplicates(filter ImageFilter, limit int, offset int) ([]DuplicateGroup, int, error) {
+	grouped := filter.where(s.db.Model(&Image{})).
+		Where("image_hash <> ''").
+		Group("image_hash").
+		Having("COUNT(*) > 1")
+
+	var total int
+	err := s.db.Raw("SELECT COUNT(*) FROM (?) AS duplicate_groups", grouped.Select("image_hash").QueryExpr()).Row().Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var rows []struct {
+		ImageHash string
+	}
+	err = grouped.Select("image_hash").
+		Order("COUNT(*) DESC").
+		Order("image_hash").
+		Limit(limit).
+		Offset(offset).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	groups := []DuplicateGroup{}
+	if len(rows) == 0 {
+		return groups, total, nil
+	}
+
+	hashes := make([]string, len(rows))
+	for i, row := range rows {
+		hashes[i] = row.ImageHash
+	}
+
+	var images []Image
+	err = filter.where(s.db).Where("image_hash IN (?)", hashes).Order("image_id").Find(&images).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return groupImages(hashes, images), total, nil
+}
+
+// groupImages sorts images into one group per hash, in the order of hashes.
+func groupImages(hashes []string, images []Image) []DuplicateGroup {
+	groups := make([]DuplicateGroup, len(hashes))
+	index := map[string]int{}
+	for i, hash := range hashes {
+		groups[i].ImageHash = hash
+		groups[i].Images = []Image{}
+		index[hash] = i
+	}
+	for _, image := range images {
+		if i, ok := index[image.ImageHash]; ok {
+			groups[i].Images = append(groups[i].Images, image)
+		}
+	}
+	return groups
+}
+
+// MemoryImageStore is an ImageStore that keeps images in memory. It is
+// meant for tests and for running the API without a database.
+type MemoryImageStore struct {
+	mu     sync.RWMutex
+	images map[int]Image
+	nextID int
+}
+
+func NewMemoryImageStore() *MemoryImageStore {
+	return &MemoryImageStore{images: map[int]Image{}, nextID: 1}
+}
+
+func (s *MemoryImageStore) Get(id int) (Image, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	image, ok := s.images[id]
+	if !ok {
+		return image, ErrImageNotFound
+	}
+	return image, nil
+}
+
+func (s *MemoryImageStore) List() ([]Image, error) {
+	images, _, err := s.Search(ImageQuery{})
+	return images, err
+}
+
+func (s *MemoryImageStore) Create(image *Image) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	image.ImageID = s.nextID
+	s.nextID++
+	s.images[image.ImageID] = *image
+	return nil
+}
+
+func (s *MemoryImageStore) Update(id int, changes Image) (Image, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	image, ok := s.images[id]
+	if !ok {
+		return image, ErrImageNotFound
+	}
+
+	// Match gorm's Updates, which skips zero valued fields.
+	dst := reflect.ValueOf(&image).Elem()
+	src := reflect.ValueOf(changes)
+	for i := 0; i < src.NumField(); i++ {
+		if !src.Field(i).IsZero() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+	image.ImageID = id
+
+	s.images[id] = image
+	return image, nil
+}
+
+func (s *MemoryImageStore) Replace(id int, image Image, keep ...string) (Image, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.images[id]
+	if !ok {
+		return existing, ErrImageNotFound
+	}
+
+	dst := reflect.ValueOf(&existing).Elem()
+	src := reflect.ValueOf(image)
+	for i := 0; i < src.NumField(); i++ {
+		if !containsField(keep, src.Type().Field(i).Name) {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+	existing.ImageID = id
+
+	s.images[id] = existing
+	return existing, nil
+}
+
+func containsField(fields []string, name string) bool {
+	for _, field := range fields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryImageStore) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.images[id]; !ok {
+		return ErrImageNotFound
+	}
+	delete(s.images, id)
+	return nil
+}
+
+func (s *MemoryImageStore) Search(query ImageQuery) ([]Image, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	images := []Image{}
+	for _, image := range s.images {
+		if query.Filter.matches(image) {
+			images = append(images, image)
+		}
+	}
+	total := len(images)
+
+	images = query.pageSlice(images)
+
+	if query.Cursor != nil && query.Cursor.Before {
+		reverseImages(images)
+	}
+	return images, total, nil
+}
+
+func (s *MemoryImageStore) Duplicates(filter ImageFilter, limit int, offset int) ([]DuplicateGroup, int, error) {
+	images, _, err := s.Search(ImageQuery{Filter: filter})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	counts := map[string]int{}
+	for _, image := range images {
+		if image.ImageHash != "" {
+			counts[image.ImageHash]++
+		}
+	}
+
+	var hashes []string
+	for hash, count := range counts {
+		if count > 1 {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		if counts[hashes[i]] != counts[hashes[j]] {
+			return counts[hashes[i]] > counts[hashes[j]]
+		}
+		return hashes[i] < hashes[j]
+	})
+
+	total := len(hashes)
+	if offset >= len(hashes) {
+		return []DuplicateGroup{}, total, nil
+	}
+	hashes = hashes[offset:]
+	if limit > 0 && len(hashes) > limit {
+		hashes = hashes[:limit]
+	}
+	return groupImages(hashes, images), total, nil
+}
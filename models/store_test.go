@@ -0,0 +1,57 @@
+package models
+
+import "testing"
+
+// Replace must clear fields that are zero in the new image, unlike Update,
+// and leave the kept fields alone.
+func TestReplaceClearsZeroFields(t *testing.T) {
+	stores := map[string]func(t *testing.T) ImageStore{
+		"memory": func(t *testing.T) ImageStore { return NewMemoryImageStore() },
+		"gorm":   newSQLiteStore,
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+
+			latitude, flash := 51.5, true
+			image := Image{
+				ImageFileName:    "a.jpg",
+				ImageOwnerID:     7,
+				ImageVisibility:  VisibilityShared,
+				ImageLensModel:   "50mm",
+				ImageISO:         400,
+				ImageLat:         "51.5",
+				ImageLatitude:    &latitude,
+				ImageFlash:       &flash,
+				ImageMegaPixels:  12,
+				ImageMetadata:    Metadata{"Make": "Canon"},
+				ImageDirLocation: "/library/a.jpg"}
+			if err := store.Create(&image); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+
+			replaced, err := store.Replace(image.ImageID, Image{ImageFileName: "a.jpg", ImageDirLocation: "/library/a.jpg", ImageWidth: 10}, "ImageOwnerID", "ImageVisibility")
+			if err != nil {
+				t.Fatalf("Replace: %v", err)
+			}
+
+			if replaced.ImageID != image.ImageID || replaced.ImageOwnerID != 7 || replaced.ImageVisibility != VisibilityShared {
+				t.Errorf("kept fields changed: id %d, owner %d, visibility %q", replaced.ImageID, replaced.ImageOwnerID, replaced.ImageVisibility)
+			}
+			if replaced.ImageWidth != 10 {
+				t.Errorf("ImageWidth = %d, want 10", replaced.ImageWidth)
+			}
+			if replaced.ImageLensModel != "" || replaced.ImageISO != 0 || replaced.ImageLat != "" || replaced.ImageMegaPixels != 0 {
+				t.Errorf("zero fields not cleared: lens %q, ISO %d, lat %q, megapixels %v", replaced.ImageLensModel, replaced.ImageISO, replaced.ImageLat, replaced.ImageMegaPixels)
+			}
+			if replaced.ImageLatitude != nil || replaced.ImageFlash != nil || len(replaced.ImageMetadata) != 0 {
+				t.Errorf("nil fields not cleared: latitude %v, flash %v, metadata %v", replaced.ImageLatitude, replaced.ImageFlash, replaced.ImageMetadata)
+			}
+
+			if _, err := store.Replace(image.ImageID+100, Image{}); err != ErrImageNotFound {
+				t.Errorf("Replace of a missing image: err = %v, want ErrImageNotFound", err)
+			}
+		})
+	}
+}
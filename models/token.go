@@ -0,0 +1,151 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+var ErrInvalidRefreshToken = errors.New("refresh token is invalid or expired")
+
+// RefreshToken is a long-lived token that is traded for a new access token.
+// Only its hash is stored. Every refresh replaces it with a new token in the
+// same family, and presenting a replaced token again revokes the family,
+// since one of the two holders must have stolen it.
+type RefreshToken struct {
+	RefreshTokenID uint   `gorm:"primary_key"`
+	UserID         uint   `gorm:"index"`
+	FamilyID       string `gorm:"index"`
+	TokenHash      string `gorm:"unique;not null"`
+	ExpiresAt      time.Time
+	RevokedAt      *time.Time
+	CreatedAt      time.Time
+}
+
+// RevokedToken is an access token ID (the jti claim) that must be refused
+// until the token would have expired anyway.
+type RevokedToken struct {
+	JTI       string    `gorm:"primary_key"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// CreateRefreshToken stores the hash of a new refresh token for a user.
+func CreateRefreshToken(userID uint, familyID string, tokenHash string, expiresAt time.Time) error {
+	return DB.Create(&RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: tokenHash,
+		ExpiresAt: expiresAt}).Error
+}
+
+// RotateRefreshToken revokes the refresh token with oldHash and stores
+// newHash in its place. It returns the token's user.
+func RotateRefreshToken(oldHash string, newHash string, expiresAt time.Time) (User, error) {
+	var user User
+	var reusedFamily string
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var old RefreshToken
+		if err := tx.Where("token_hash = ?", oldHash).First(&old).Error; err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				return ErrInvalidRefreshToken
+			}
+			return err
+		}
+
+		if old.RevokedAt != nil {
+			reusedFamily = old.FamilyID
+			return ErrInvalidRefreshToken
+		}
+		if time.Now().After(old.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		// The revoked_at check keeps two concurrent refreshes from both
+		// succeeding with the same token.
+		result := tx.Model(&RefreshToken{}).
+			Where("refresh_token_id = ? AND revoked_at IS NULL", old.RefreshTokenID).
+			Update("revoked_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return ErrInvalidRefreshToken
+		}
+
+		if err := tx.Where("user_id = ?", old.UserID).First(&user).Error; err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				return ErrInvalidRefreshToken
+			}
+			return err
+		}
+		if user.UserDisabled {
+			return ErrUserDisabled
+		}
+
+		return tx.Create(&RefreshToken{
+			UserID:    old.UserID,
+			FamilyID:  old.FamilyID,
+			TokenHash: newHash,
+			ExpiresAt: expiresAt}).Error
+	})
+
+	// Revoked outside the transaction, which the error rolls back.
+	if reusedFamily != "" {
+		if err := revokeRefreshTokens(DB, "family_id = ?", reusedFamily); err != nil {
+			return User{}, err
+		}
+	}
+
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// RevokeRefreshToken revokes the refresh token with tokenHash together with
+// the rest of its family. Unknown tokens are ignored.
+func RevokeRefreshToken(tokenHash string) error {
+	var token RefreshToken
+	if err := DB.Where("token_hash = ?", tokenHash).First(&token).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil
+		}
+		return err
+	}
+	return revokeRefreshTokens(DB, "family_id = ?", token.FamilyID)
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of a user.
+func RevokeUserRefreshTokens(userID uint) error {
+	return revokeRefreshTokens(DB, "user_id = ?", userID)
+}
+
+func revokeRefreshTokens(db *gorm.DB, where string, value interface{}) error {
+	return db.Model(&RefreshToken{}).
+		Where(where, value).
+		Where("revoked_at IS NULL").
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeTokenID adds an access token ID to the denylist. Entries whose
+// tokens have expired are dropped at the same time. A token that is already
+// on the list, say from a concurrent logout, is left as it is.
+func RevokeTokenID(jti string, expiresAt time.Time) error {
+	if err := DB.Where("expires_at < ?", time.Now()).Delete(&RevokedToken{}).Error; err != nil {
+		return err
+	}
+
+	err := DB.Create(&RevokedToken{JTI: jti, ExpiresAt: expiresAt}).Error
+	if isUniqueViolation(err) {
+		return nil
+	}
+	return err
+}
+
+// IsTokenRevoked reports whether an access token ID is on the denylist.
+func IsTokenRevoked(jti string) (bool, error) {
+	var count int
+	err := DB.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User roles, from most to least privileged. Admins manage users and may
+// see and change every image, editors add and change their own images and
+// viewers may only read.
+const (
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+var roleRanks = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleAdmin: 3}
+
+var (
+	ErrUserDisabled       = errors.New("user is disabled")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUserExists         = errors.New("username is already taken")
+	ErrInvalidCredentials = errors.New("username or password is incorrect")
+)
+
+type User struct {
+	UserID       uint   `gorm:"primary_key"`
+	Username     string `gorm:"unique;not null"`
+	PasswordHash string `gorm:"not null" json:"-"`
+	UserRole     string
+	UserDisabled bool
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// HasRole reports whether role grants at least the rights of required.
+func HasRole(role string, required string) bool {
+	return ValidRole(role) && roleRanks[role] >= roleRanks[required]
+}
+
+// CreateUser stores a new user with a bcrypt hash of password. A taken
+// username is caught by the unique index, so concurrent registrations of
+// the same name cannot both succeed.
+func CreateUser(username string, password string, role string) (User, error) {
+	username = strings.TrimSpace(username)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+
+	user := User{Username: username, PasswordHash: string(hash), UserRole: role}
+	if err := DB.Create(&user).Error; err != nil {
+		if isUniqueViolation(err) {
+			return User{}, ErrUserExists
+		}
+		return User{}, err
+	}
+	return user, nil
+}
+
+// LoginCheck returns the user with username if password matches.
+func LoginCheck(username string, password string) (User, error) {
+	var user User
+	err := DB.Where("username = ?", strings.TrimSpace(username)).First(&user).Error
+	if err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return User{}, ErrInvalidCredentials
+		}
+		return User{}, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return User{}, ErrInvalidCredentials
+	}
+	if user.UserDisabled {
+		return User{}, ErrUserDisabled
+	}
+	return user, nil
+}
+
+func ListUsers() ([]User, error) {
+	var users []User
+	err := DB.Order("user_id").Find(&users).Error
+	return users, err
+}
+
+// UpdateUser changes the role and disabled flag of a user. An empty role or
+// nil disabled leaves that field as it is.
+func UpdateUser(id uint, role string, disabled *bool) (User, error) {
+	user, err := GetUserByID(id)
+	if err != nil {
+		return user, err
+	}
+
+	changes := map[string]interface{}{}
+	if role != "" {
+		changes["user_role"] = role
+	}
+	if disabled != nil {
+		changes["user_disabled"] = *disabled
+	}
+	if len(changes) == 0 {
+		return user, nil
+	}
+
+	if err := DB.Model(&user).Updates(changes).Error; err != nil {
+		return user, err
+	}
+
+	// A disabled user must not be able to refresh their way back in.
+	if disabled != nil && *disabled {
+		if err := RevokeUserRefreshTokens(id); err != nil {
+			return user, err
+		}
+	}
+	return GetUserByID(id)
+}
+
+func GetUserByID(id uint) (User, error) {
+	var user User
+	err := DB.Where("user_id = ?", id).First(&user).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return User{}, ErrUserNotFound
+	}
+	return user, err
+}
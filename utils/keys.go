@@ -0,0 +1,337 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Tokens are signed with the private key in the PEM file JWT_SIGNING_KEY,
+// RS256 for RSA keys and EdDSA for Ed25519 keys, and name the key in their
+// kid header. JWT_VERIFICATION_KEYS lists further PEM files whose keys are
+// still accepted, so a new signing key can be rolled out while tokens signed
+// with the old one are in use. Key IDs are RFC 7638 thumbprints, so a key
+// keeps its ID when it moves from signing to verification only.
+//
+// Without JWT_SIGNING_KEY tokens are signed with HS256 and API_SECRET, and
+// HS256 tokens without a kid are accepted as long as API_SECRET is set. Once
+// JWT_SIGNING_KEY is set they are rejected, since anyone who knows the secret
+// could forge them, unless JWT_ACCEPT_LEGACY_HS256 is true to let tokens
+// issued before the switch run out.
+
+// SigningMethodEdDSA signs tokens with Ed25519 keys, which jwt-go does not
+// support itself.
+var SigningMethodEdDSA = &signingMethodEdDSA{}
+
+type signingMethodEdDSA struct{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+func (m *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+func (m *signingMethodEdDSA) Verify(signingString string, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+// tokenKey is an asymmetric key with the method and ID tokens use with it.
+// privateKey is nil for keys that only verify.
+type tokenKey struct {
+	kid        string
+	method     jwt.SigningMethod
+	privateKey crypto.PrivateKey
+	publicKey  crypto.PublicKey
+}
+
+type keySet struct {
+	signing *tokenKey
+	verify  map[string]*tokenKey
+	// kids lists the verification keys in configuration order.
+	kids []string
+	// acceptLegacy accepts HS256 tokens alongside a signing key.
+	acceptLegacy bool
+}
+
+var (
+	keysOnce sync.Once
+	keys     *keySet
+	keysErr  error
+)
+
+// LoadKeys reads the configured token keys. It is called on start so bad
+// key files are reported right away; otherwise the keys are read when
+// first needed.
+func LoadKeys() error {
+	keysOnce.Do(func() {
+		keys, keysErr = loadKeySet(os.Getenv("JWT_SIGNING_KEY"), os.Getenv("JWT_VERIFICATION_KEYS"))
+		if keysErr != nil {
+			return
+		}
+		if value := os.Getenv("JWT_ACCEPT_LEGACY_HS256"); value != "" {
+			keys.acceptLegacy, keysErr = strconv.ParseBool(value)
+			if keysErr != nil {
+				keysErr = fmt.Errorf("JWT_ACCEPT_LEGACY_HS256 must be true or false")
+			}
+		}
+	})
+	return keysErr
+}
+
+func loadKeySet(signingPath string, verificationPaths string) (*keySet, error) {
+	set := &keySet{verify: map[string]*tokenKey{}}
+
+	add := func(key *tokenKey) {
+		if _, ok := set.verify[key.kid]; !ok {
+			set.verify[key.kid] = key
+			set.kids = append(set.kids, key.kid)
+		}
+	}
+
+	if signingPath != "" {
+		key, err := readKeyFile(signingPath)
+		if err != nil {
+			return nil, err
+		}
+		if key.privateKey == nil {
+			return nil, fmt.Errorf("%s: JWT_SIGNING_KEY must hold a private key", signingPath)
+		}
+		set.signing = key
+		add(key)
+	}
+
+	for _, path := range strings.Split(verificationPaths, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		key, err := readKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key.privateKey = nil
+		add(key)
+	}
+
+	return set, nil
+}
+
+// readKeyFile loads an RSA or Ed25519 key from a PEM file holding either a
+// private key (PKCS #1 or #8) or a public key (PKIX or PKCS #1).
+func readKeyFile(path string) (*tokenKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	key := &tokenKey{}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.privateKey, key.publicKey = k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.publicKey = k
+	case ed25519.PrivateKey:
+		key.privateKey, key.publicKey = k, k.Public()
+	case ed25519.PublicKey:
+		key.publicKey = k
+	default:
+		return nil, fmt.Errorf("%s: only RSA and Ed25519 keys are supported", path)
+	}
+
+	switch k := key.publicKey.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("%s: RSA keys must have at least 2048 bits", path)
+		}
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.method = SigningMethodEdDSA
+	}
+
+	key.kid = thumbprint(key.jwk())
+	return key, nil
+}
+
+// JWK is a public key in JSON Web Key form.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSet is the body of a JWKS endpoint.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys tokens are verified with.
+func JWKS() (JWKSet, error) {
+	set := JWKSet{Keys: []JWK{}}
+	if err := LoadKeys(); err != nil {
+		return set, err
+	}
+
+	for _, kid := range keys.kids {
+		key := keys.verify[kid]
+		jwk := key.jwk()
+		jwk.Kid = key.kid
+		jwk.Use = "sig"
+		jwk.Alg = key.method.Alg()
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, nil
+}
+
+// jwk returns the required members of the key's JWK.
+func (key *tokenKey) jwk() JWK {
+	switch k := key.publicKey.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())}
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(k)}
+	}
+	return JWK{}
+}
+
+// thumbprint is the RFC 7638 SHA-256 thumbprint of a JWK: the hash of its
+// required members in lexical order.
+func thumbprint(jwk JWK) string {
+	var data []byte
+	switch jwk.Kty {
+	case "RSA":
+		data, _ = json.Marshal(struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N})
+	default:
+		data, _ = json.Marshal(struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X})
+	}
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// signToken signs claims with the signing key, or with HS256 and API_SECRET
+// when there is none.
+func signToken(claims jwt.MapClaims) (string, error) {
+	if err := LoadKeys(); err != nil {
+		return "", err
+	}
+
+	if key := keys.signing; key != nil {
+		token := jwt.NewWithClaims(key.method, claims)
+		token.Header["kid"] = key.kid
+		return token.SignedString(key.privateKey)
+	}
+
+	secret := os.Getenv("API_SECRET")
+	if secret == "" {
+		return "", errors.New("no token signing key: set JWT_SIGNING_KEY or API_SECRET")
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(secret))
+}
+
+// verificationKey picks the key to check a token with from its kid header.
+// The token's alg must be the one that key is used with, so a public key
+// can never be used as an HMAC secret.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	if err := LoadKeys(); err != nil {
+		return nil, err
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if keys.signing != nil && !keys.acceptLegacy {
+			return nil, fmt.Errorf("Token has no signing key ID")
+		}
+		secret := os.Getenv("API_SECRET")
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || secret == "" {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(secret), nil
+	}
+
+	key, ok := keys.verify[kid]
+	if !ok {
+		return nil, fmt.Errorf("Unknown signing key: %v", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.publicKey, nil
+}
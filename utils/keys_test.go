@@ -0,0 +1,186 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// writeKey writes a PKCS #8 private key to a PEM file and returns its path.
+func writeKey(t *testing.T, key interface{}) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// useKeys loads the key files as LoadKeys would and, when the test ends,
+// leaves the keys to be loaded from the environment again.
+func useKeys(t *testing.T, signingPath string, verificationPaths string, acceptLegacy bool) {
+	t.Helper()
+
+	set, err := loadKeySet(signingPath, verificationPaths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set.acceptLegacy = acceptLegacy
+
+	keys, keysErr = set, nil
+	keysOnce = sync.Once{}
+	keysOnce.Do(func() {})
+	t.Cleanup(func() {
+		keys, keysErr = nil, nil
+		keysOnce = sync.Once{}
+	})
+}
+
+func claims() jwt.MapClaims {
+	return jwt.MapClaims{"user_id": 1, "exp": time.Now().Add(time.Hour).Unix()}
+}
+
+// sign signs a token with method and key, naming kid when it is set.
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, kid string) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims())
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	tokenString, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokenString
+}
+
+func valid(tokenString string) bool {
+	token, err := jwt.Parse(tokenString, verificationKey)
+	return err == nil && token.Valid
+}
+
+func TestVerificationKey(t *testing.T) {
+	t.Setenv("API_SECRET", "test secret")
+	secret := []byte("test secret")
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPath, edPath := writeKey(t, rsaKey), writeKey(t, edKey)
+
+	rsaKid := thumbprint((&tokenKey{publicKey: &rsaKey.PublicKey}).jwk())
+	edKid := thumbprint((&tokenKey{publicKey: edKey.Public()}).jwk())
+	rsaPublic, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPublicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaPublic})
+
+	t.Run("signing and verification keys", func(t *testing.T) {
+		useKeys(t, rsaPath, edPath, false)
+
+		tokenString, err := signToken(claims())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !valid(tokenString) {
+			t.Error("token signed with the signing key was rejected")
+		}
+		if !valid(sign(t, SigningMethodEdDSA, edKey, edKid)) {
+			t.Error("token signed with a verification key was rejected")
+		}
+	})
+
+	t.Run("alg does not match the key", func(t *testing.T) {
+		useKeys(t, rsaPath, edPath, false)
+
+		// The RSA public key is known to everyone, so it must never be
+		// taken as an HMAC secret.
+		if valid(sign(t, jwt.SigningMethodHS256, rsaPublicPEM, rsaKid)) {
+			t.Error("HS256 token signed with the RSA public key was accepted")
+		}
+		if valid(sign(t, jwt.SigningMethodHS256, rsaPublic, rsaKid)) {
+			t.Error("HS256 token signed with the DER RSA public key was accepted")
+		}
+		if valid(sign(t, SigningMethodEdDSA, edKey, rsaKid)) {
+			t.Error("EdDSA token naming the RSA key was accepted")
+		}
+		if valid(sign(t, jwt.SigningMethodRS256, rsaKey, edKid)) {
+			t.Error("RS256 token naming the Ed25519 key was accepted")
+		}
+	})
+
+	t.Run("unknown kid", func(t *testing.T) {
+		useKeys(t, rsaPath, "", false)
+
+		otherKid := thumbprint((&tokenKey{publicKey: otherKey.Public()}).jwk())
+		if valid(sign(t, SigningMethodEdDSA, otherKey, otherKid)) {
+			t.Error("token signed with an unknown key was accepted")
+		}
+		if valid(sign(t, SigningMethodEdDSA, edKey, edKid)) {
+			t.Error("token signed with an unconfigured key was accepted")
+		}
+	})
+
+	t.Run("legacy HS256 with a signing key", func(t *testing.T) {
+		useKeys(t, rsaPath, "", false)
+
+		if valid(sign(t, jwt.SigningMethodHS256, secret, "")) {
+			t.Error("HS256 token without a kid was accepted")
+		}
+	})
+
+	t.Run("legacy HS256 accepted", func(t *testing.T) {
+		useKeys(t, rsaPath, "", true)
+
+		if !valid(sign(t, jwt.SigningMethodHS256, secret, "")) {
+			t.Error("HS256 token without a kid was rejected")
+		}
+		if valid(sign(t, jwt.SigningMethodHS256, []byte("wrong secret"), "")) {
+			t.Error("HS256 token with the wrong secret was accepted")
+		}
+		if valid(sign(t, jwt.SigningMethodRS256, rsaKey, "")) {
+			t.Error("RS256 token without a kid was accepted")
+		}
+	})
+
+	t.Run("HS256 without a signing key", func(t *testing.T) {
+		useKeys(t, "", "", false)
+
+		tokenString, err := signToken(claims())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !valid(tokenString) {
+			t.Error("HS256 token was rejected")
+		}
+		if valid(sign(t, jwt.SigningMethodRS256, rsaKey, rsaKid)) {
+			t.Error("token naming an unconfigured key was accepted")
+		}
+	})
+}
@@ -1,7 +1,12 @@
 package token
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"imageApi/models"
 	"os"
 	"strconv"
 	"strings"
@@ -11,35 +16,123 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func GenerateToken(user_id uint) (string, error) {
+// Access tokens live for ACCESS_TOKEN_MINUTE_LIFESPAN minutes and are renewed
+// with a refresh token, which lives for REFRESH_TOKEN_HOUR_LIFESPAN hours.
+const (
+	defaultAccessTokenMinutes = 15
+	defaultRefreshTokenHours  = 30 * 24
+)
+
+func AccessTokenLifespan() time.Duration {
+	return envDuration("ACCESS_TOKEN_MINUTE_LIFESPAN", defaultAccessTokenMinutes, time.Minute)
+}
 
-	token_lifespan, err := strconv.Atoi(os.Getenv("TOKEN_HOUR_LIFESPAN"))
+func RefreshTokenLifespan() time.Duration {
+	return envDuration("REFRESH_TOKEN_HOUR_LIFESPAN", defaultRefreshTokenHours, time.Hour)
+}
+
+func envDuration(key string, def int, unit time.Duration) time.Duration {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || n <= 0 {
+		n = def
+	}
+	return time.Duration(n) * unit
+}
 
+func GenerateToken(user_id uint, role string) (string, error) {
+
+	jti, err := randomString(16)
 	if err != nil {
 		return "", err
 	}
 
+	now := time.Now()
 	claims := jwt.MapClaims{}
 	claims["authorized"] = true
 	claims["user_id"] = user_id
-	claims["exp"] = time.Now().Add(time.Hour * time.Duration(token_lifespan)).Unix()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	// The role is only for clients to read. Requests are authorized with
+	// the role the user has now.
+	claims["role"] = role
+	claims["jti"] = jti
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(AccessTokenLifespan()).Unix()
+
+	return signToken(claims)
+
+}
+
+// GenerateRefreshToken returns a new random refresh token and the hash to
+// store for it.
+func GenerateRefreshToken() (string, string, error) {
+	refreshToken, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	return refreshToken, HashRefreshToken(refreshToken), nil
+}
+
+func HashRefreshToken(refreshToken string) string {
+	return hashSecret(refreshToken)
+}
+
+// API keys start with apiKeyPrefix so they are easy to spot in scripts and
+// secret scanners.
+const apiKeyPrefix = "iak_"
+
+// GenerateAPIKey returns a new random API key and the hash to store for it.
+func GenerateAPIKey() (string, string, error) {
+	secret, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	key := apiKeyPrefix + secret
+	return key, HashAPIKey(key), nil
+}
+
+func HashAPIKey(key string) string {
+	return hashSecret(key)
+}
+
+// ExtractAPIKey returns the key from the X-API-Key header.
+func ExtractAPIKey(c *gin.Context) string {
+	return c.Request.Header.Get("X-API-Key")
+}
 
-	return token.SignedString([]byte(os.Getenv("API_SECRET")))
+// Refresh tokens and API keys are long random strings, so a plain SHA-256
+// is enough to keep them useless when read from the database.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
 
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
+// TokenValid checks the request's token signature and expiry, and that its
+// jti has not been revoked.
 func TokenValid(c *gin.Context) error {
-	tokenString := ExtractToken(c)
-	_, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("API_SECRET")), nil
-	})
+	token, err := parseToken(c)
 	if err != nil {
 		return err
 	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return fmt.Errorf("Unexpected claims")
+	}
+	if jti, _ := claims["jti"].(string); jti != "" {
+		revoked, err := models.IsTokenRevoked(jti)
+		if err != nil {
+			return err
+		}
+		if revoked {
+			return fmt.Errorf("Token has been revoked")
+		}
+	}
 	return nil
 }
 
@@ -55,15 +148,14 @@ func ExtractToken(c *gin.Context) string {
 	return ""
 }
 
+func parseToken(c *gin.Context) (*jwt.Token, error) {
+	tokenString := ExtractToken(c)
+	return jwt.Parse(tokenString, verificationKey)
+}
+
 func ExtractTokenID(c *gin.Context) (uint, error) {
 
-	tokenString := ExtractToken(c)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("API_SECRET")), nil
-	})
+	token, err := parseToken(c)
 	if err != nil {
 		return 0, err
 	}
@@ -77,3 +169,20 @@ func ExtractTokenID(c *gin.Context) (uint, error) {
 	}
 	return 0, nil
 }
+
+// ExtractTokenJTI returns the token's ID and expiry time. Tokens issued
+// before IDs were added have an empty ID.
+func ExtractTokenJTI(c *gin.Context) (string, time.Time, error) {
+
+	token, err := parseToken(c)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if ok && token.Valid {
+		jti, _ := claims["jti"].(string)
+		exp, _ := claims["exp"].(float64)
+		return jti, time.Unix(int64(exp), 0), nil
+	}
+	return "", time.Time{}, nil
+}